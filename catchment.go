@@ -0,0 +1,175 @@
+// catchment maps each probe to the anycast site which served it, and reports
+// the catchment table: probe metro to serving site, with counts and latency.
+//
+// The site is identified, by -id, from the answers of dns measurements:
+// resolver uses the resolver-identity.cloudfront.net TXT answer which the gdns
+// templates query, the address of the resolver serving the probe; nsid uses
+// the EDNS0 NSID option of queries made with set_nsid_bit; chaos uses the
+// hostname.bind or id.server CHAOS TXT answer; auto uses the first of nsid,
+// chaos and resolver found in each answer.
+//
+//	go run catchment.go -mids 18811137,18811138 -sites sites.csv
+//	go run catchment.go -tags google-public-dns,recursive -far 1500
+//	go run catchment.go -mids 10001 -id chaos
+//
+// The optional sites file maps resolver prefixes to named, located, sites:
+// prefix,site[,lat,long]
+//
+// Along with the catchment table, each probe's stability is reported: how
+// often the site serving it flipped over time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/catchment"
+	"github.com/morrowc/ripe-atlas/compare"
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/probes"
+	"github.com/morrowc/ripe-atlas/results"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+var (
+	mids        = flag.String("mids", "", "Comma separated list of measurement IDs to map.")
+	tags        = flag.String("tags", "", "Comma separated list of tags to find measurements by.")
+	description = flag.String("description", "", "Text the description of measurements must contain.")
+	sitesFile   = flag.String("sites", "", "CSV file mapping resolver prefixes to sites: prefix,site[,lat,long]")
+	idMethod    = flag.String("id", "auto", "How to identify the site: resolver, nsid, chaos or auto.")
+	far         = flag.Float64("far", 2000, "Flag probes served by a site further than this (km), for sites located by -sites.")
+	start       = flag.String("start", "", "Only results after this time: unix seconds, RFC3339 or relative (-1h).")
+	stop        = flag.String("stop", "", "Only results before this time: unix seconds, RFC3339 or relative (-1h).")
+	verbose     = flag.Bool("verbose", false, "Report the sites serving each probe.")
+)
+
+// findMeasurements returns the measurements requested on the command-line.
+func findMeasurements() ([]messages.MeasurementResponseMessage, error) {
+	if *mids == "" {
+		return results.Search(*tags, *description)
+	}
+	ids, err := results.ParseIds(*mids)
+	if err != nil {
+		return nil, err
+	}
+	var res []messages.MeasurementResponseMessage
+	for _, id := range ids {
+		m, err := results.Measurement(int(id))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *m)
+	}
+	return res, nil
+}
+
+func main() {
+	flag.Parse()
+
+	if *mids == "" && *tags == "" && *description == "" {
+		fmt.Printf("Provide -mids, or -tags/-description to find measurements.\n")
+		return
+	}
+
+	now := time.Now()
+	var q results.Query
+	var err error
+	if q.Start, err = results.ParseTime(*start, now); err != nil {
+		fmt.Printf("bad -start: %v\n", err)
+		return
+	}
+	if q.Stop, err = results.ParseTime(*stop, now); err != nil {
+		fmt.Printf("bad -stop: %v\n", err)
+		return
+	}
+
+	var sites *catchment.SiteMap
+	if *sitesFile != "" {
+		if sites, err = catchment.LoadSites(*sitesFile); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+
+	ms, err := findMeasurements()
+	if err != nil {
+		fmt.Printf("failed to find measurements: %v\n", err)
+		return
+	}
+
+	c := catchment.New()
+	probeSites := map[int32]map[string]int{}
+	for _, m := range ms {
+		metro := compare.Metro(m.Description)
+		if metro == "" {
+			metro = fmt.Sprintf("msm-%d", m.Id)
+		}
+		it, err := results.Fetch(int(m.Id), &q)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		err = it.Each(func(rec messages.MeasurementResultMessage) {
+			// Results without an identity are kept, so their failures count.
+			site, err := catchment.Identify(*idMethod, rec)
+			if err != nil {
+				site = "none"
+			}
+			for _, s := range stats.Samples(rec) {
+				c.Add(catchment.Observation{Mid: m.Id, Metro: metro, Site: site, Sample: s})
+			}
+			if probeSites[rec.PrbId] == nil {
+				probeSites[rec.PrbId] = map[string]int{}
+			}
+			probeSites[rec.PrbId][sites.Lookup(site).Name]++
+		}, func(de *results.DecodeError) {
+			fmt.Printf("Skipping result of %d: %v\n", m.Id, de)
+		})
+		it.Close()
+		if err != nil {
+			fmt.Printf("failed reading the results of %d: %v\n", m.Id, err)
+		}
+	}
+
+	// Probe locations give the distance to each located site.
+	var ids []int32
+	for id := range probeSites {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	details, err := probes.Lookup(ids)
+	if err != nil {
+		fmt.Printf("failed to look up the probes, distances are unknown: %v\n", err)
+	}
+
+	fmt.Printf("Catchment:\n")
+	for _, r := range c.Table(sites, details, *far) {
+		fmt.Printf("\t%v\n", r)
+	}
+	fmt.Printf("Sites:\n")
+	for _, r := range c.Sites(sites, details, *far) {
+		fmt.Printf("\t%v\n", r)
+	}
+
+	// Probes which flipped between sites are reported, all probes if verbose.
+	stability := c.Stability(sites)
+	flipped := 0
+	for _, s := range stability {
+		if s.Flips > 0 {
+			flipped++
+		}
+	}
+	fmt.Printf("Stability: %d of %d probes changed sites\n", flipped, len(stability))
+	for _, s := range stability {
+		if s.Flips > 0 || *verbose {
+			fmt.Printf("\t%v\n", s)
+		}
+	}
+	if *verbose {
+		for _, id := range ids {
+			fmt.Printf("\tProbe: %d sites: %v\n", id, probeSites[id])
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// catchment maps probes to the anycast site which served them, building a
+// catchment table: probe metro to serving site, with counts and latency, and
+// the stability of each probe's site over time.
+package catchment
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/morrowc/ripe-atlas/dnsmsg"
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/probes"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+// ResolverIdentity returns the address of the resolver which served a probe,
+// from the answer to a resolver-identity.cloudfront.net TXT query. The TXT
+// record holds the address the resolver queried the authoritative servers from.
+func ResolverIdentity(m messages.MeasurementResultMessage) (string, error) {
+	msg, err := dnsmsg.Result(m)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range msg.Answers {
+		if rr.Type != dnsmsg.TypeTXT {
+			continue
+		}
+		for _, s := range rr.TXT() {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+				return ip.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no resolver address in the answer(%v)", msg.RcodeName())
+}
+
+// NSID returns the name server identifier, the EDNS0 NSID option, from the
+// answer of a query made with set_nsid_bit.
+func NSID(m messages.MeasurementResultMessage) (string, error) {
+	msg, err := dnsmsg.Result(m)
+	if err != nil {
+		return "", err
+	}
+	if id, ok := msg.NSID(); ok && id != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("no nsid in the answer(%v)", msg.RcodeName())
+}
+
+// Chaos returns the server identity from the answer to a CHAOS class TXT
+// query for hostname.bind or id.server.
+func Chaos(m messages.MeasurementResultMessage) (string, error) {
+	msg, err := dnsmsg.Result(m)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range msg.Answers {
+		if rr.Type != dnsmsg.TypeTXT {
+			continue
+		}
+		name := strings.ToLower(rr.Name)
+		if rr.Class != dnsmsg.ClassCHAOS && name != "hostname.bind." && name != "id.server." {
+			continue
+		}
+		if txt := rr.TXT(); len(txt) > 0 {
+			return strings.Join(txt, ""), nil
+		}
+	}
+	return "", fmt.Errorf("no chaos identity in the answer(%v)", msg.RcodeName())
+}
+
+// Identify returns the identity of the site which answered a dns result, by
+// method: resolver (resolver-identity TXT), nsid, chaos (hostname.bind or
+// id.server TXT) or auto, which tries nsid, then chaos, then resolver.
+func Identify(method string, m messages.MeasurementResultMessage) (string, error) {
+	switch method {
+	case "resolver":
+		return ResolverIdentity(m)
+	case "nsid":
+		return NSID(m)
+	case "chaos":
+		return Chaos(m)
+	case "auto":
+		if id, err := NSID(m); err == nil {
+			return id, nil
+		}
+		if id, err := Chaos(m); err == nil {
+			return id, nil
+		}
+		return ResolverIdentity(m)
+	}
+	return "", fmt.Errorf("unknown identity method(%v), use resolver, nsid, chaos or auto", method)
+}
+
+// Site is an anycast site, with its location when known.
+type Site struct {
+	Name      string
+	Lat, Long float64
+	Located   bool
+}
+
+type siteEntry struct {
+	net  *net.IPNet
+	site Site
+}
+
+// SiteMap maps resolver addresses to the sites they belong to.
+type SiteMap struct {
+	entries []siteEntry
+}
+
+// LoadSites reads a site map from a CSV file of: prefix,site[,lat,long]
+// for example: 172.253.1.0/24,iad,38.94,-77.46
+// Lines starting with # are comments.
+func LoadSites(path string) (*SiteMap, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	r := csv.NewReader(fd)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	var sm SiteMap
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the site map(%v): %v", path, err)
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("site map(%v) line %v: want prefix,site[,lat,long]", path, rec)
+		}
+		_, n, err := net.ParseCIDR(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return nil, fmt.Errorf("site map(%v): %v", path, err)
+		}
+		e := siteEntry{net: n, site: Site{Name: strings.TrimSpace(rec[1])}}
+		if len(rec) >= 4 {
+			lat, errLat := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+			long, errLong := strconv.ParseFloat(strings.TrimSpace(rec[3]), 64)
+			if errLat != nil || errLong != nil {
+				return nil, fmt.Errorf("site map(%v): bad location for %v", path, rec[0])
+			}
+			e.site.Lat, e.site.Long, e.site.Located = lat, long, true
+		}
+		sm.entries = append(sm.entries, e)
+	}
+	return &sm, nil
+}
+
+// Lookup returns the site of a resolver address, the longest matching
+// prefix wins. An address not in the map is its own site, named by the
+// covering /24 (IPv4) or /48 (IPv6).
+func (s *SiteMap) Lookup(addr string) Site {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return Site{Name: addr}
+	}
+	if s != nil {
+		best := -1
+		var site Site
+		for _, e := range s.entries {
+			if !e.net.Contains(ip) {
+				continue
+			}
+			if ones, _ := e.net.Mask.Size(); ones > best {
+				best = ones
+				site = e.site
+			}
+		}
+		if best >= 0 {
+			return site
+		}
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return Site{Name: (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()}
+	}
+	return Site{Name: (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()}
+}
+
+// Observation is a single result's view of which site served a probe.
+type Observation struct {
+	// Mid is the measurement of the result.
+	Mid    int32
+	Metro  string
+	Site   string
+	Sample stats.Sample
+}
+
+// Catchment accumulates observations, to build the catchment table.
+type Catchment struct {
+	obs []Observation
+}
+
+// New creates an empty Catchment.
+func New() *Catchment {
+	return &Catchment{}
+}
+
+// Add records a single observation.
+func (c *Catchment) Add(o Observation) {
+	c.obs = append(c.obs, o)
+}
+
+// Row is a single entry in the catchment table: the probes of a metro
+// served by a single site.
+type Row struct {
+	Metro   string
+	Site    Site
+	Probes  []int32
+	Results int
+	// Share is the fraction of the metro's results served by this site.
+	Share   float64
+	Latency stats.Summary
+	// Distance is the mean distance (km) from the probes to the site, when
+	// both are located.
+	Distance float64
+	Far      bool
+	// FarProbes are the probes further than farKm from the site, each
+	// flagged whatever the mean distance.
+	FarProbes []int32
+}
+
+func (r Row) String() string {
+	res := fmt.Sprintf("%v -> %v probes: %d results: %d share: %0.1f%% p50: %0.3f p95: %0.3f",
+		r.Metro, r.Site.Name, len(r.Probes), r.Results, 100*r.Share, r.Latency.P50, r.Latency.P95)
+	if r.Distance > 0 {
+		res += fmt.Sprintf(" distance: %0.0fkm", r.Distance)
+	}
+	if r.Far {
+		res += " FAR"
+	}
+	if len(r.FarProbes) > 0 {
+		res += fmt.Sprintf(" far probes: %d %v", len(r.FarProbes), r.FarProbes)
+	}
+	return res
+}
+
+// Table builds the catchment table, sorted by metro and share. Sites are
+// resolved through sites, which may be nil. details supplies probe locations
+// for the distance to each site; rows further than farKm, on average, are
+// flagged Far, and each probe further than farKm is listed in FarProbes.
+func (c *Catchment) Table(sites *SiteMap, details map[int32]messages.ProbeMessage, farKm float64) []Row {
+	return c.table(sites, details, farKm, func(o Observation) string { return o.Metro })
+}
+
+// Sites groups the probes by the site which served them, across all metros.
+// The rows are sorted by share, the Metro of each is "*".
+func (c *Catchment) Sites(sites *SiteMap, details map[int32]messages.ProbeMessage, farKm float64) []Row {
+	return c.table(sites, details, farKm, func(o Observation) string { return "*" })
+}
+
+func (c *Catchment) table(sites *SiteMap, details map[int32]messages.ProbeMessage, farKm float64, metroOf func(Observation) string) []Row {
+	type rowKey struct{ metro, site string }
+	rows := map[rowKey]*Row{}
+	values := map[rowKey]*stats.Values{}
+	probeSet := map[rowKey]map[int32]bool{}
+	metroResults := map[string]int{}
+
+	for _, o := range c.obs {
+		metro := metroOf(o)
+		k := rowKey{metro, sites.Lookup(o.Site).Name}
+		r, ok := rows[k]
+		if !ok {
+			r = &Row{Metro: metro, Site: sites.Lookup(o.Site)}
+			rows[k] = r
+			values[k] = &stats.Values{}
+			probeSet[k] = map[int32]bool{}
+		}
+		r.Results++
+		metroResults[metro]++
+		values[k].Add(o.Sample)
+		if !probeSet[k][o.Sample.PrbId] {
+			probeSet[k][o.Sample.PrbId] = true
+			r.Probes = append(r.Probes, o.Sample.PrbId)
+		}
+	}
+
+	var res []Row
+	for k, r := range rows {
+		r.Share = float64(r.Results) / float64(metroResults[r.Metro])
+		r.Latency = values[k].Summary()
+		sort.Slice(r.Probes, func(i, j int) bool { return r.Probes[i] < r.Probes[j] })
+
+		if r.Site.Located {
+			var total float64
+			var n int
+			for _, id := range r.Probes {
+				lat, long, ok := probes.Location(details[id])
+				if !ok {
+					continue
+				}
+				d := probes.Distance(lat, long, r.Site.Lat, r.Site.Long)
+				if farKm > 0 && d > farKm {
+					r.FarProbes = append(r.FarProbes, id)
+				}
+				total += d
+				n++
+			}
+			if n > 0 {
+				r.Distance = total / float64(n)
+				r.Far = farKm > 0 && r.Distance > farKm
+			}
+		}
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Metro != res[j].Metro {
+			return res[i].Metro < res[j].Metro
+		}
+		return res[i].Share > res[j].Share
+	})
+	return res
+}
+
+// Stability describes how steadily a single probe was served by one site.
+type Stability struct {
+	PrbId int32
+	// Results is the number of results which identified a site.
+	Results int
+	// Sites is the number of distinct sites which served the probe.
+	Sites int
+	// Flips is the number of times the serving site changed, in time order.
+	Flips int
+	// Site is the site which served the most results, and Share its fraction.
+	Site  string
+	Share float64
+
+	// timelines is the number of measurements the probe has results in.
+	timelines int
+}
+
+// FlipRate is the fraction of consecutive results, of a measurement, where
+// the site changed.
+func (s Stability) FlipRate() float64 {
+	if s.Results-s.timelines < 1 {
+		return 0
+	}
+	return float64(s.Flips) / float64(s.Results-s.timelines)
+}
+
+func (s Stability) String() string {
+	return fmt.Sprintf("Probe: %d results: %d sites: %d flips: %d (%0.2f%%) main site: %v (%0.1f%%)",
+		s.PrbId, s.Results, s.Sites, s.Flips, 100*s.FlipRate(), s.Site, 100*s.Share)
+}
+
+// Stability returns the site stability of each probe, sorted by flips, most
+// first. Flips are counted along the timeline of each measurement the probe
+// is in, so v4 and v6, or recursive and non-recursive, results are not
+// mixed. Observations without a site identity (site "none") are ignored.
+func (c *Catchment) Stability(sites *SiteMap) []Stability {
+	type point struct {
+		ts   int32
+		site string
+	}
+	type timelineKey struct{ mid, prbId int32 }
+	timelines := map[timelineKey][]point{}
+	for _, o := range c.obs {
+		if o.Site == "none" {
+			continue
+		}
+		k := timelineKey{o.Mid, o.Sample.PrbId}
+		timelines[k] = append(timelines[k], point{o.Sample.Timestamp, sites.Lookup(o.Site).Name})
+	}
+
+	byProbe := map[int32]*Stability{}
+	counts := map[int32]map[string]int{}
+	for k, pts := range timelines {
+		sort.SliceStable(pts, func(i, j int) bool { return pts[i].ts < pts[j].ts })
+		s, ok := byProbe[k.prbId]
+		if !ok {
+			s = &Stability{PrbId: k.prbId}
+			byProbe[k.prbId] = s
+			counts[k.prbId] = map[string]int{}
+		}
+		s.timelines++
+		var last point
+		for i, p := range pts {
+			// Several samples of a single result share a timestamp, within
+			// a single measurement.
+			if i > 0 && p.ts == last.ts {
+				continue
+			}
+			if i > 0 && p.site != last.site {
+				s.Flips++
+			}
+			s.Results++
+			counts[k.prbId][p.site]++
+			last = p
+		}
+	}
+
+	var res []Stability
+	for id, s := range byProbe {
+		s.Sites = len(counts[id])
+		for site, n := range counts[id] {
+			if n > counts[id][s.Site] || (n == counts[id][s.Site] && site < s.Site) {
+				s.Site = site
+			}
+		}
+		s.Share = float64(counts[id][s.Site]) / float64(s.Results)
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Flips != res[j].Flips {
+			return res[i].Flips > res[j].Flips
+		}
+		return res[i].PrbId < res[j].PrbId
+	})
+	return res
+}
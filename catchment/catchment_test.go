@@ -0,0 +1,143 @@
+package catchment
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+func testSites(t *testing.T) *SiteMap {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sites.csv")
+	csv := "# prefix,site,lat,long\n" +
+		"172.253.0.0/16,google\n" +
+		"172.253.1.0/24,fra,50.03,8.57\n" +
+		"172.253.2.0/24,fra,50.03,8.57\n" +
+		"172.253.3.0/24,iad,38.94,-77.46\n"
+	if err := ioutil.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sm, err := LoadSites(path)
+	if err != nil {
+		t.Fatalf("LoadSites() failed: %v", err)
+	}
+	return sm
+}
+
+func TestLookup(t *testing.T) {
+	sm := testSites(t)
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"172.253.1.5", "fra"},
+		{"172.253.9.5", "google"},
+		{"192.0.2.77", "192.0.2.0/24"},
+		{"2001:db8:1:2::53", "2001:db8:1::/48"},
+		{"not an address", "not an address"},
+	} {
+		if got := sm.Lookup(tc.addr).Name; got != tc.want {
+			t.Errorf("Lookup(%v) = %v, want %v", tc.addr, got, tc.want)
+		}
+	}
+	// Without a site map, addresses are their own sites.
+	var none *SiteMap
+	if got := none.Lookup("172.253.1.5").Name; got != "172.253.1.0/24" {
+		t.Errorf("nil Lookup() = %v, want 172.253.1.0/24", got)
+	}
+}
+
+func TestResolverIdentity(t *testing.T) {
+	msg := []byte{
+		0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0,
+		// resolver-identity.cloudfront.net. TXT IN
+		17, 'r', 'e', 's', 'o', 'l', 'v', 'e', 'r', '-', 'i', 'd', 'e', 'n', 't', 'i', 't', 'y',
+		10, 'c', 'l', 'o', 'u', 'd', 'f', 'r', 'o', 'n', 't', 3, 'n', 'e', 't', 0,
+		0, 16, 0, 1,
+		0xc0, 12, 0, 16, 0, 1, 0, 0, 0, 60, 0, 12,
+		11, '1', '7', '2', '.', '2', '5', '3', '.', '1', '.', '5',
+	}
+	var m messages.MeasurementResultMessage
+	m.Type = "dns"
+	m.Result.Abuf = base64.StdEncoding.EncodeToString(msg)
+	if got, err := ResolverIdentity(m); err != nil || got != "172.253.1.5" {
+		t.Errorf("ResolverIdentity() = %v, %v, want 172.253.1.5", got, err)
+	}
+	m.Result.Abuf = base64.StdEncoding.EncodeToString(msg[:len(msg)-12])
+	if got, err := ResolverIdentity(m); err == nil {
+		t.Errorf("ResolverIdentity() of a truncated answer = %v, want an error", got)
+	}
+}
+
+func TestTable(t *testing.T) {
+	c := New()
+	add := func(metro, site string, prbId int32, rtt float64) {
+		c.Add(Observation{Mid: 1, Metro: metro, Site: site, Sample: stats.Sample{PrbId: prbId, Rtt: rtt}})
+	}
+	// Both FRA resolver prefixes are one site, so one row.
+	add("FRA", "172.253.1.5", 1, 10)
+	add("FRA", "172.253.2.9", 2, 20)
+	add("FRA", "172.253.1.5", 3, 30)
+	add("FRA", "172.253.3.1", 3, 90)
+	details := map[int32]messages.ProbeMessage{
+		1: {Id: 1, Geometry: messages.GeometryMsg{Coordinates: []float32{8.57, 50.03}}},
+		2: {Id: 2, Geometry: messages.GeometryMsg{Coordinates: []float32{8.57, 50.13}}},
+		// 3 is in Lisbon, about 1900km from Frankfurt.
+		3: {Id: 3, Geometry: messages.GeometryMsg{Coordinates: []float32{-9.14, 38.72}}},
+	}
+
+	rows := c.Table(testSites(t), details, 1000)
+	if len(rows) != 2 {
+		t.Fatalf("Table() = %v, want 2 rows", rows)
+	}
+	fra, iad := rows[0], rows[1]
+	if fra.Site.Name != "fra" || !reflect.DeepEqual(fra.Probes, []int32{1, 2, 3}) || fra.Results != 3 || fra.Share != 0.75 {
+		t.Errorf("Table() row 1 = %v, want fra probes [1 2 3] results 3 share 0.75", fra)
+	}
+	// The mean distance is under 1000km, but 3 is far all the same.
+	if fra.Far || !reflect.DeepEqual(fra.FarProbes, []int32{3}) {
+		t.Errorf("Table() row 1 far: %v far probes: %v, want false [3]", fra.Far, fra.FarProbes)
+	}
+	if iad.Site.Name != "iad" || !iad.Far || !reflect.DeepEqual(iad.FarProbes, []int32{3}) {
+		t.Errorf("Table() row 2 = %v, want a far iad", iad)
+	}
+
+	sites := c.Sites(testSites(t), details, 0)
+	if len(sites) != 2 || sites[0].Metro != "*" || sites[0].Site.Name != "fra" || len(sites[0].FarProbes) != 0 {
+		t.Errorf("Sites() = %v, want fra first, without far probes", sites)
+	}
+}
+
+func TestStability(t *testing.T) {
+	c := New()
+	add := func(mid int32, ts int32, site string) {
+		c.Add(Observation{Mid: mid, Metro: "FRA", Site: site, Sample: stats.Sample{PrbId: 1, Timestamp: ts}})
+	}
+	// Measurement 1 flips once, a repeated sample of a result is not a
+	// flip, and measurement 2 being served elsewhere is not a flip either.
+	add(1, 100, "172.253.1.5")
+	add(1, 400, "172.253.1.5")
+	add(1, 700, "172.253.3.1")
+	add(1, 700, "172.253.1.5")
+	add(1, 1000, "none")
+	add(2, 100, "172.253.3.1")
+	add(2, 400, "172.253.3.1")
+
+	got := c.Stability(testSites(t))
+	if len(got) != 1 {
+		t.Fatalf("Stability() = %v, want one probe", got)
+	}
+	s := got[0]
+	if s.Results != 5 || s.Sites != 2 || s.Flips != 1 || s.Site != "iad" || s.Share != 0.6 {
+		t.Errorf("Stability() = %+v, want 5 results, 2 sites, 1 flip, mostly iad", s)
+	}
+	// 5 results over 2 timelines are 3 consecutive pairs.
+	if r := s.FlipRate(); r != 1.0/3 {
+		t.Errorf("FlipRate() = %v, want 1/3", r)
+	}
+}
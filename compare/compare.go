@@ -0,0 +1,405 @@
+// compare pairs two measurements' results by probe and time bucket, to
+// report how one (B) differs from the other (A), e.g. IPv6 against IPv4.
+package compare
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/results"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+// metroRE extracts the metro from a description made from the templates
+// in measurements/, which end: "from metro: %v".
+var metroRE = regexp.MustCompile(`metro:\s*(\S+)`)
+
+// Metro returns the metro named in a measurement description, upper-cased,
+// or an empty string when there is none.
+func Metro(description string) string {
+	m := metroRE.FindStringSubmatch(description)
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1])
+}
+
+// Pair is a pair of measurements, from a single metro, to compare.
+type Pair struct {
+	Metro string
+	// Kind is what both measurements are: their type, and the kind given
+	// to FindPairs, e.g. dns/recursive.
+	Kind string
+	A, B messages.MeasurementResponseMessage
+}
+
+// Recursion returns whether a dns measurement is "recursive" or
+// "non-recursive", from the tags of the measurement templates when present,
+// otherwise the description. It is empty when neither says.
+func Recursion(m messages.MeasurementResponseMessage) string {
+	for _, t := range m.Tags {
+		if t == "non-recursive" || t == "recursive" {
+			return t
+		}
+	}
+	d := strings.ToLower(m.Description)
+	switch {
+	case strings.Contains(d, "authoritative"), strings.Contains(d, "non-recursive"):
+		return "non-recursive"
+	case strings.Contains(d, "recursive"):
+		return "recursive"
+	}
+	return ""
+}
+
+// Tags returns the measurement's tags which are not the address family or
+// Recursion, sorted and joined with "+": what else the measurement is, such
+// as largequery, which must match for two measurements to pair.
+func Tags(m messages.MeasurementResponseMessage) string {
+	var res []string
+	for _, t := range m.Tags {
+		switch t {
+		case "ipv4", "ipv6", "recursive", "non-recursive":
+			continue
+		}
+		res = append(res, t)
+	}
+	sort.Strings(res)
+	return strings.Join(res, "+")
+}
+
+// Unpaired is a measurement FindPairs left out, and why.
+type Unpaired struct {
+	M      messages.MeasurementResponseMessage
+	Reason string
+}
+
+func (u Unpaired) String() string {
+	return fmt.Sprintf("Unpaired measurement %d (af %d target %v %q): %v", u.M.Id, u.M.Af, u.M.Target, u.M.Description, u.Reason)
+}
+
+// FindPairs groups measurements by the metro in their description, and pairs
+// the A and B measurements of each metro. side reports which side of the pair
+// a measurement belongs to: 'A', 'B' or 0 for neither. Only measurements of
+// the same type, Tags and kind are paired: kind names what must match besides
+// the side, such as the address family, target or Recursion. Where a side has
+// several measurements the newest is paired, the others are duplicates.
+// Pairs are sorted by metro and kind, and the measurements left out are
+// returned as Unpaired.
+func FindPairs(ms []messages.MeasurementResponseMessage, side func(messages.MeasurementResponseMessage) byte, kind func(messages.MeasurementResponseMessage) string) ([]Pair, []Unpaired) {
+	type pairKey struct{ metro, kind string }
+	pairs := map[pairKey]*Pair{}
+	var unpaired []Unpaired
+	for _, m := range ms {
+		metro := Metro(m.Description)
+		s := side(m)
+		switch {
+		case metro == "":
+			unpaired = append(unpaired, Unpaired{m, "no metro in the description"})
+			continue
+		case s == 0:
+			unpaired = append(unpaired, Unpaired{m, "neither side of a pair"})
+			continue
+		}
+		k := pairKey{metro, m.Type}
+		for _, kd := range []string{kind(m), Tags(m)} {
+			if kd != "" {
+				k.kind += "/" + kd
+			}
+		}
+		p, ok := pairs[k]
+		if !ok {
+			p = &Pair{Metro: metro, Kind: k.kind}
+			pairs[k] = p
+		}
+		cur := &p.A
+		if s == 'B' {
+			cur = &p.B
+		}
+		switch {
+		case cur.Id == 0:
+			*cur = m
+		case m.Id > cur.Id:
+			unpaired = append(unpaired, Unpaired{*cur, fmt.Sprintf("duplicate of %d, which is newer", m.Id)})
+			*cur = m
+		default:
+			unpaired = append(unpaired, Unpaired{m, fmt.Sprintf("duplicate of %d, which is newer", cur.Id)})
+		}
+	}
+
+	var res []Pair
+	for _, p := range pairs {
+		switch {
+		case p.A.Id != 0 && p.B.Id != 0:
+			res = append(res, *p)
+		case p.A.Id != 0:
+			unpaired = append(unpaired, Unpaired{p.A, fmt.Sprintf("nothing to pair with in %v %v", p.Metro, p.Kind)})
+		default:
+			unpaired = append(unpaired, Unpaired{p.B, fmt.Sprintf("nothing to pair with in %v %v", p.Metro, p.Kind)})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Metro != res[j].Metro {
+			return res[i].Metro < res[j].Metro
+		}
+		return res[i].Kind < res[j].Kind
+	})
+	sort.Slice(unpaired, func(i, j int) bool { return unpaired[i].M.Id < unpaired[j].M.Id })
+	return res, unpaired
+}
+
+// key identifies a probe's results within a single time bucket.
+type key struct {
+	prbId  int32
+	bucket int32
+}
+
+// Pairing joins the results of two measurements, by probe and time bucket.
+// Only buckets in which a probe has results in both measurements are compared.
+type Pairing struct {
+	bucket       int32
+	nameA, nameB string
+	a, b         map[key][]stats.Sample
+	// dnsA and dnsB hold the first dns answer in each bucket.
+	dnsA, dnsB map[key]dnsAnswer
+}
+
+// NewPairing creates a Pairing which joins results within buckets of the
+// given width, typically the measurement interval. The names label the A
+// and B sides in reports, e.g. "v4" and "v6".
+func NewPairing(bucket time.Duration, nameA, nameB string) *Pairing {
+	b := int32(bucket / time.Second)
+	if b < 1 {
+		b = 1
+	}
+	return &Pairing{
+		bucket: b,
+		nameA:  nameA,
+		nameB:  nameB,
+		a:      map[key][]stats.Sample{},
+		b:      map[key][]stats.Sample{},
+		dnsA:   map[key]dnsAnswer{},
+		dnsB:   map[key]dnsAnswer{},
+	}
+}
+
+// AddA records a result from the A measurement.
+func (p *Pairing) AddA(m messages.MeasurementResultMessage) {
+	p.add(p.a, p.dnsA, m)
+}
+
+// AddB records a result from the B measurement.
+func (p *Pairing) AddB(m messages.MeasurementResultMessage) {
+	p.add(p.b, p.dnsB, m)
+}
+
+func (p *Pairing) add(side map[key][]stats.Sample, dns map[key]dnsAnswer, m messages.MeasurementResultMessage) {
+	k := key{prbId: m.PrbId, bucket: m.Timestamp / p.bucket}
+	side[k] = append(side[k], stats.Samples(m)...)
+	if _, ok := dns[k]; !ok && m.Type == "dns" {
+		dns[k] = newDNSAnswer(m)
+	}
+}
+
+// ProbeDelta compares the A and B results of a single probe.
+type ProbeDelta struct {
+	PrbId        int32
+	Buckets      int
+	NameA, NameB string
+	A, B         stats.Summary
+	DNS          DNSDiff
+}
+
+// LatencyDelta is the B median latency less the A median latency.
+func (d ProbeDelta) LatencyDelta() float64 {
+	return d.B.P50 - d.A.P50
+}
+
+// FailureDelta is the B failure rate less the A failure rate.
+func (d ProbeDelta) FailureDelta() float64 {
+	return d.B.FailureRate() - d.A.FailureRate()
+}
+
+// BWorse reports whether B fared worse than A: a higher failure rate or,
+// failures being equal, a higher median latency.
+func (d ProbeDelta) BWorse() bool {
+	if fd := d.FailureDelta(); fd != 0 {
+		return fd > 0
+	}
+	return d.LatencyDelta() > 0
+}
+
+func (d ProbeDelta) String() string {
+	return fmt.Sprintf(
+		"Probe: %d buckets: %d p50 %v: %0.3f %v: %0.3f delta: %+0.3f failures %v: %0.2f%% %v: %0.2f%% delta: %+0.2f%%",
+		d.PrbId, d.Buckets, d.NameA, d.A.P50, d.NameB, d.B.P50, d.LatencyDelta(),
+		d.NameA, 100*d.A.FailureRate(), d.NameB, 100*d.B.FailureRate(), 100*d.FailureDelta()) +
+		d.DNS.String()
+}
+
+// Report is the comparison of the joined results, overall and per probe.
+type Report struct {
+	Buckets      int
+	NameA, NameB string
+	A, B         stats.Summary
+	Probes       []ProbeDelta
+	DNS          DNSDiff
+	// Skipped are the results which failed to decode, left out of the report.
+	Skipped []Skipped
+}
+
+// Skipped is a result which failed to decode, and was left out.
+type Skipped struct {
+	Id  int32
+	Err *results.DecodeError
+}
+
+func (s Skipped) String() string {
+	return fmt.Sprintf("Skipping result of %d: %v", s.Id, s.Err)
+}
+
+// Report joins the results added so far, and compares them.
+func (p *Pairing) Report() *Report {
+	var all [2]stats.Values
+	var allDNS DNSDiff
+	probes := map[int32]*[2]stats.Values{}
+	probeDNS := map[int32]*DNSDiff{}
+	buckets := map[int32]int{}
+	for k, as := range p.a {
+		bs, ok := p.b[k]
+		if !ok {
+			continue
+		}
+		v, ok := probes[k.prbId]
+		if !ok {
+			v = &[2]stats.Values{}
+			probes[k.prbId] = v
+		}
+		buckets[k.prbId]++
+		for _, s := range as {
+			all[0].Add(s)
+			v[0].Add(s)
+		}
+		for _, s := range bs {
+			all[1].Add(s)
+			v[1].Add(s)
+		}
+
+		da, okA := p.dnsA[k]
+		db, okB := p.dnsB[k]
+		if okA && okB {
+			pd, ok := probeDNS[k.prbId]
+			if !ok {
+				pd = &DNSDiff{}
+				probeDNS[k.prbId] = pd
+			}
+			allDNS.add(da, db)
+			pd.add(da, db)
+		}
+	}
+
+	r := &Report{
+		NameA: p.nameA,
+		NameB: p.nameB,
+		A:     all[0].Summary(),
+		B:     all[1].Summary(),
+		DNS:   allDNS,
+	}
+	for id, v := range probes {
+		r.Buckets += buckets[id]
+		r.Probes = append(r.Probes, ProbeDelta{
+			PrbId:   id,
+			Buckets: buckets[id],
+			NameA:   p.nameA,
+			NameB:   p.nameB,
+			A:       v[0].Summary(),
+			B:       v[1].Summary(),
+		})
+		if pd, ok := probeDNS[id]; ok {
+			r.Probes[len(r.Probes)-1].DNS = *pd
+		}
+	}
+	sort.Slice(r.Probes, func(i, j int) bool { return r.Probes[i].PrbId < r.Probes[j].PrbId })
+	return r
+}
+
+// LatencyDelta is the overall B median latency less the A median latency.
+func (r *Report) LatencyDelta() float64 {
+	return r.B.P50 - r.A.P50
+}
+
+// FailureDelta is the overall B failure rate less the A failure rate.
+func (r *Report) FailureDelta() float64 {
+	return r.B.FailureRate() - r.A.FailureRate()
+}
+
+// BWorse returns the number of probes for which B fared worse than A.
+func (r *Report) BWorse() int {
+	n := 0
+	for _, p := range r.Probes {
+		if p.BWorse() {
+			n++
+		}
+	}
+	return n
+}
+
+// BWorseShare is the fraction of probes for which B fared worse than A.
+func (r *Report) BWorseShare() float64 {
+	if len(r.Probes) == 0 {
+		return 0
+	}
+	return float64(r.BWorse()) / float64(len(r.Probes))
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf(
+		"probes: %d buckets: %d p50 %v: %0.3f %v: %0.3f delta: %+0.3f failures %v: %0.2f%% %v: %0.2f%% delta: %+0.2f%% %v worse: %d/%d (%0.1f%%)",
+		len(r.Probes), r.Buckets, r.NameA, r.A.P50, r.NameB, r.B.P50, r.LatencyDelta(),
+		r.NameA, 100*r.A.FailureRate(), r.NameB, 100*r.B.FailureRate(), 100*r.FailureDelta(),
+		r.NameB, r.BWorse(), len(r.Probes), 100*r.BWorseShare()) +
+		r.DNS.String()
+}
+
+// Fetch reads the results of both measurements in a pair which match the
+// query, and compares them. A zero bucket joins on the slower of the two
+// measurement intervals. Results which fail to decode are skipped, and
+// listed in the report's Skipped.
+func Fetch(p Pair, bucket time.Duration, q *results.Query, nameA, nameB string) (*Report, error) {
+	if bucket == 0 {
+		iv := p.A.Interval
+		if p.B.Interval > iv {
+			iv = p.B.Interval
+		}
+		bucket = time.Duration(iv) * time.Second
+	}
+	pairing := NewPairing(bucket, nameA, nameB)
+	var skipped []Skipped
+
+	for _, side := range []struct {
+		id  int32
+		add func(messages.MeasurementResultMessage)
+	}{
+		{p.A.Id, pairing.AddA},
+		{p.B.Id, pairing.AddB},
+	} {
+		it, err := results.Fetch(int(side.id), q)
+		if err != nil {
+			return nil, err
+		}
+		err = it.Each(side.add, func(de *results.DecodeError) {
+			skipped = append(skipped, Skipped{Id: side.id, Err: de})
+		})
+		it.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading the results of %d: %v", side.id, err)
+		}
+	}
+	r := pairing.Report()
+	r.Skipped = skipped
+	return r, nil
+}
@@ -0,0 +1,100 @@
+package compare
+
+import (
+	"testing"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// gdns returns a measurement made from one of the google-public-dns
+// templates in measurements/, in the FRA metro.
+func gdns(id int32, af int, target, description string, tags ...string) messages.MeasurementResponseMessage {
+	return messages.MeasurementResponseMessage{
+		Id:          id,
+		Af:          af,
+		Type:        "dns",
+		Target:      target,
+		Description: description + " from metro: fra",
+		Tags:        append([]string{"google-public-dns"}, tags...),
+	}
+}
+
+func byAf(m messages.MeasurementResponseMessage) byte {
+	switch m.Af {
+	case 4:
+		return 'A'
+	case 6:
+		return 'B'
+	}
+	return 0
+}
+
+func TestFindPairs(t *testing.T) {
+	ms := []messages.MeasurementResponseMessage{
+		gdns(1, 4, "8.8.4.4", "UDP/53 recursive test for 8.8.4.4", "ipv4", "recursive"),
+		gdns(2, 6, "2001:4860:4860::8844", "UDP/53 recursive test for 2001:4860:4860::8844", "ipv6", "recursive"),
+		gdns(3, 4, "8.8.4.4", "UDP/53 authoritative test for 8.8.4.4", "ipv4", "non-recursive"),
+		gdns(4, 6, "2001:4860:4860::8844", "UDP/53 authoritative test for 2001:4860:4860::8844", "ipv6", "non-recursive"),
+		// gdns_v6only_auth_large is v4 and recursive, like 1.
+		gdns(5, 4, "8.8.4.4", "UDP/53 recursive large query test for 8.8.4.4", "ipv4", "recursive", "largequery"),
+		// A repeat of 3, replacing it.
+		gdns(6, 4, "8.8.4.4", "UDP/53 authoritative test for 8.8.4.4", "ipv4", "non-recursive"),
+		{Id: 7, Af: 4, Type: "dns", Description: "no metro"},
+	}
+
+	pairs, unpaired := FindPairs(ms, byAf, Recursion)
+	want := []struct {
+		kind string
+		a, b int32
+	}{
+		{"dns/non-recursive/google-public-dns", 6, 4},
+		{"dns/recursive/google-public-dns", 1, 2},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("FindPairs() = %d pairs, want %d", len(pairs), len(want))
+	}
+	for i, w := range want {
+		p := pairs[i]
+		if p.Metro != "FRA" || p.Kind != w.kind || p.A.Id != w.a || p.B.Id != w.b {
+			t.Errorf("pair %d = %v %v %d %d, want FRA %v %d %d", i, p.Metro, p.Kind, p.A.Id, p.B.Id, w.kind, w.a, w.b)
+		}
+	}
+	wantUnpaired := []struct {
+		id     int32
+		reason string
+	}{
+		{3, "duplicate of 6, which is newer"},
+		{5, "nothing to pair with in FRA dns/recursive/google-public-dns+largequery"},
+		{7, "no metro in the description"},
+	}
+	if len(unpaired) != len(wantUnpaired) {
+		t.Fatalf("FindPairs() unpaired = %v, want %d", unpaired, len(wantUnpaired))
+	}
+	for i, w := range wantUnpaired {
+		if u := unpaired[i]; u.M.Id != w.id || u.Reason != w.reason {
+			t.Errorf("unpaired %d = %d %q, want %d %q", i, u.M.Id, u.Reason, w.id, w.reason)
+		}
+	}
+}
+
+func TestFindPairsTarget(t *testing.T) {
+	rd := func(m messages.MeasurementResponseMessage) byte {
+		if Recursion(m) == "recursive" {
+			return 'B'
+		}
+		return 'A'
+	}
+	target := func(m messages.MeasurementResponseMessage) string { return m.Target }
+	ms := []messages.MeasurementResponseMessage{
+		gdns(1, 4, "8.8.4.4", "UDP/53 recursive test for 8.8.4.4", "recursive"),
+		gdns(2, 4, "8.8.4.4", "UDP/53 authoritative test for 8.8.4.4", "non-recursive"),
+		gdns(3, 4, "8.8.8.8", "UDP/53 recursive test for 8.8.8.8", "recursive"),
+	}
+	pairs, unpaired := FindPairs(ms, rd, target)
+	if len(pairs) != 1 || pairs[0].A.Id != 2 || pairs[0].B.Id != 1 {
+		t.Errorf("FindPairs() = %v, want 2 paired with 1", pairs)
+	}
+	if len(unpaired) != 1 || unpaired[0].M.Id != 3 {
+		t.Errorf("FindPairs() unpaired = %v, want 3", unpaired)
+	}
+}
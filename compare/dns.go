@@ -0,0 +1,80 @@
+package compare
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/morrowc/ripe-atlas/dnsmsg"
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// dnsAnswer is the outcome of a single dns result: the response code, or
+// the failure, and the answer section in a comparable form.
+type dnsAnswer struct {
+	rcode   string
+	answers string
+}
+
+func newDNSAnswer(m messages.MeasurementResultMessage) dnsAnswer {
+	switch {
+	case m.TimedOut():
+		return dnsAnswer{rcode: "TIMEOUT"}
+	case m.Failed():
+		return dnsAnswer{rcode: "ERROR"}
+	}
+	msg, err := dnsmsg.Result(m)
+	if err != nil {
+		return dnsAnswer{rcode: "UNPARSED"}
+	}
+	var answers []string
+	for _, rr := range msg.Answers {
+		answers = append(answers, fmt.Sprintf("%v %v", dnsmsg.TypeName(rr.Type), rr))
+	}
+	sort.Strings(answers)
+	return dnsAnswer{rcode: msg.RcodeName(), answers: strings.Join(answers, "; ")}
+}
+
+// DNSDiff counts how the dns answers of paired results differ.
+type DNSDiff struct {
+	// Pairs is the number of buckets with a dns result on both sides.
+	Pairs int
+	// Rcodes counts the pairs by response code, as "A rcode/B rcode".
+	Rcodes map[string]int
+	// RcodeDiffs is the number of pairs with differing response codes.
+	RcodeDiffs int
+	// AnswerDiffs is the number of pairs with the same response code, but
+	// differing answer sections.
+	AnswerDiffs int
+}
+
+func (d *DNSDiff) add(a, b dnsAnswer) {
+	if d.Rcodes == nil {
+		d.Rcodes = map[string]int{}
+	}
+	d.Pairs++
+	d.Rcodes[a.rcode+"/"+b.rcode]++
+	switch {
+	case a.rcode != b.rcode:
+		d.RcodeDiffs++
+	case a.answers != b.answers:
+		d.AnswerDiffs++
+	}
+}
+
+// String reports the differences, it is empty when no dns results were paired.
+func (d DNSDiff) String() string {
+	if d.Pairs == 0 {
+		return ""
+	}
+	var rcodes []string
+	for k := range d.Rcodes {
+		rcodes = append(rcodes, k)
+	}
+	sort.Strings(rcodes)
+	for i, k := range rcodes {
+		rcodes[i] = fmt.Sprintf("%v: %d", k, d.Rcodes[k])
+	}
+	return fmt.Sprintf(" dns pairs: %d rcode diffs: %d answer diffs: %d rcodes: [%v]",
+		d.Pairs, d.RcodeDiffs, d.AnswerDiffs, strings.Join(rcodes, ", "))
+}
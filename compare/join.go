@@ -0,0 +1,232 @@
+package compare
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/results"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+// Column is a single measurement in a joined table.
+type Column struct {
+	Id   int32
+	Type string
+}
+
+// Name labels the column with its measurement id and what its values are:
+// the median latency (ms) or, for traceroute, the hop count.
+func (c Column) Name() string {
+	if c.Type == "traceroute" {
+		return fmt.Sprintf("%d/%v-hops", c.Id, c.Type)
+	}
+	return fmt.Sprintf("%d/%v-ms", c.Id, c.Type)
+}
+
+// Cell is a single measurement's value, for one probe in one time bucket.
+// A cell without a value had no results, or no answers, in the bucket.
+type Cell struct {
+	Value float64
+	Valid bool
+}
+
+func (c Cell) String() string {
+	if !c.Valid {
+		return "-"
+	}
+	return strconv.FormatFloat(c.Value, 'f', 3, 64)
+}
+
+// JoinRow is a single probe's values, for one time bucket, side by side.
+type JoinRow struct {
+	PrbId int32
+	Start time.Time
+	Cells []Cell
+}
+
+// Table is the joined results of several measurements.
+type Table struct {
+	Columns []Column
+	Rows    []JoinRow
+	// Skipped are the results which failed to decode, left out of the table.
+	Skipped []Skipped
+}
+
+type joinKey struct {
+	prbId  int32
+	bucket int32
+}
+
+// Joiner aligns the results of several measurements by probe and time bucket.
+type Joiner struct {
+	bucket int32
+	cols   []Column
+	values map[joinKey]map[int][]float64
+}
+
+// NewJoiner creates a Joiner which aligns results in buckets of the given width.
+func NewJoiner(bucket time.Duration) *Joiner {
+	b := int32(bucket / time.Second)
+	if b < 1 {
+		b = 1
+	}
+	return &Joiner{bucket: b, values: map[joinKey]map[int][]float64{}}
+}
+
+// AddColumn adds a measurement to the table, returning its column number.
+func (j *Joiner) AddColumn(m messages.MeasurementResponseMessage) int {
+	j.cols = append(j.cols, Column{Id: m.Id, Type: m.Type})
+	return len(j.cols) - 1
+}
+
+// Add records a result of the measurement in column col.
+func (j *Joiner) Add(col int, m messages.MeasurementResultMessage) {
+	k := joinKey{prbId: m.PrbId, bucket: m.Timestamp - m.Timestamp%j.bucket}
+	v, ok := j.values[k]
+	if !ok {
+		v = map[int][]float64{}
+		j.values[k] = v
+	}
+	if m.Type == "traceroute" {
+		if h, ok := hops(m); ok {
+			v[col] = append(v[col], float64(h))
+		}
+		return
+	}
+	for _, s := range stats.Samples(m) {
+		if !s.Timeout && !s.Error {
+			v[col] = append(v[col], s.Rtt)
+		}
+	}
+}
+
+// hops returns the hop at which a traceroute's destination replied. A trace
+// which did not reach it, ending at hop 255 or with only timeouts from the
+// destination, has no hop count.
+func hops(m messages.MeasurementResultMessage) (int, bool) {
+	for i := len(m.Result.Entries) - 1; i >= 0; i-- {
+		e := m.Result.Entries[i]
+		for _, r := range e.Replies {
+			if !r.Timeout() && r.From == m.DstAddr {
+				return e.Hop, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Table returns the joined table, one row per probe and bucket in which any
+// measurement had results, sorted by probe and time. Each cell is the median
+// of the values in its bucket.
+func (j *Joiner) Table() *Table {
+	t := &Table{Columns: j.cols}
+	for k, v := range j.values {
+		r := JoinRow{
+			PrbId: k.prbId,
+			Start: time.Unix(int64(k.bucket), 0).UTC(),
+			Cells: make([]Cell, len(j.cols)),
+		}
+		for col, vals := range v {
+			if len(vals) > 0 {
+				r.Cells[col] = Cell{Value: stats.Percentile(stats.Sorted(vals), 50), Valid: true}
+			}
+		}
+		t.Rows = append(t.Rows, r)
+	}
+	sort.Slice(t.Rows, func(a, b int) bool {
+		if t.Rows[a].PrbId != t.Rows[b].PrbId {
+			return t.Rows[a].PrbId < t.Rows[b].PrbId
+		}
+		return t.Rows[a].Start.Before(t.Rows[b].Start)
+	})
+	return t
+}
+
+// Join reads the results of the measurements which match the query, and
+// aligns them by probe and time bucket. Results which fail to decode are
+// skipped, and listed in the table's Skipped.
+func Join(ms []messages.MeasurementResponseMessage, bucket time.Duration, q *results.Query) (*Table, error) {
+	j := NewJoiner(bucket)
+	var skipped []Skipped
+	for _, m := range ms {
+		col := j.AddColumn(m)
+		it, err := results.Fetch(int(m.Id), q)
+		if err != nil {
+			return nil, err
+		}
+		err = it.Each(func(rec messages.MeasurementResultMessage) {
+			j.Add(col, rec)
+		}, func(de *results.DecodeError) {
+			skipped = append(skipped, Skipped{Id: m.Id, Err: de})
+		})
+		it.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading the results of %d: %v", m.Id, err)
+		}
+	}
+	t := j.Table()
+	t.Skipped = skipped
+	return t, nil
+}
+
+func (t *Table) header() []string {
+	res := []string{"probe", "time"}
+	for _, c := range t.Columns {
+		res = append(res, c.Name())
+	}
+	return res
+}
+
+func (r JoinRow) fields() []string {
+	res := []string{strconv.Itoa(int(r.PrbId)), r.Start.Format(time.RFC3339)}
+	for _, c := range r.Cells {
+		res = append(res, c.String())
+	}
+	return res
+}
+
+// String is the table as text, in aligned columns.
+func (t *Table) String() string {
+	lines := [][]string{t.header()}
+	for _, r := range t.Rows {
+		lines = append(lines, r.fields())
+	}
+	widths := make([]int, len(lines[0]))
+	for _, l := range lines {
+		for i, f := range l {
+			if len(f) > widths[i] {
+				widths[i] = len(f)
+			}
+		}
+	}
+	var res []string
+	for _, l := range lines {
+		var fs []string
+		for i, f := range l {
+			fs = append(fs, fmt.Sprintf("%-*s", widths[i], f))
+		}
+		res = append(res, strings.TrimRight(strings.Join(fs, "  "), " "))
+	}
+	return strings.Join(res, "\n")
+}
+
+// WriteCSV writes the table as CSV, with a header line.
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.header()); err != nil {
+		return err
+	}
+	for _, r := range t.Rows {
+		if err := cw.Write(r.fields()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,38 @@
+package compare
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+func TestJoinTracerouteHops(t *testing.T) {
+	body, err := ioutil.ReadFile("../messages/testdata/traceroute.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []messages.MeasurementResultMessage
+	if err := json.Unmarshal(body, &ms); err != nil {
+		t.Fatalf("failed to decode the traceroutes: %v", err)
+	}
+
+	j := NewJoiner(5 * time.Minute)
+	col := j.AddColumn(messages.MeasurementResponseMessage{Id: 5001, Type: "traceroute"})
+	for _, m := range ms {
+		j.Add(col, m)
+	}
+	tbl := j.Table()
+	if len(tbl.Rows) != 2 {
+		t.Fatalf("Table() = %d rows, want 2", len(tbl.Rows))
+	}
+	// The first reaches 8.8.8.8 at hop 4, the second is unreachable and
+	// ends at hop 255, which is no hop count.
+	for i, want := range []Cell{{Value: 4, Valid: true}, {}} {
+		if got := tbl.Rows[i].Cells[col]; got != want {
+			t.Errorf("probe %d: hops = %v, want %v", tbl.Rows[i].PrbId, got, want)
+		}
+	}
+}
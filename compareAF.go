@@ -0,0 +1,130 @@
+// compareAF reports how IPv6 fares against IPv4, per metro and per probe,
+// by pairing the v4 and v6 measurements made for each metro.
+//
+// Measurements are given directly:
+//
+//	go run compareAF.go -v4 18811137 -v6 18811138
+//
+// or found by tags and/or description, and paired by the metro named in their
+// description:
+//
+//	go run compareAF.go -tags google-public-dns,recursive
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/compare"
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/results"
+)
+
+var (
+	v4Id        = flag.Int("v4", 0, "IPv4 measurement ID to compare.")
+	v6Id        = flag.Int("v6", 0, "IPv6 measurement ID to compare.")
+	metro       = flag.String("metro", "", "Metro to label a -v4/-v6 pair with, defaults to the metro in the description.")
+	tags        = flag.String("tags", "", "Comma separated list of tags to find measurement pairs by.")
+	description = flag.String("description", "", "Text the description of measurement pairs must contain.")
+	bucket      = flag.Duration("bucket", 0, "Time bucket to join results in, defaults to the measurement interval.")
+	start       = flag.String("start", "", "Only results after this time: unix seconds, RFC3339 or relative (-1h).")
+	stop        = flag.String("stop", "", "Only results before this time: unix seconds, RFC3339 or relative (-1h).")
+	verbose     = flag.Bool("verbose", true, "Report each probe, as well as each metro.")
+)
+
+// findPairs returns the measurement pairs requested on the command-line, and
+// the measurements found which could not be paired.
+func findPairs() ([]compare.Pair, []compare.Unpaired, error) {
+	if *v4Id != 0 && *v6Id != 0 {
+		a, err := results.Measurement(*v4Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := results.Measurement(*v6Id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if a.Af != 4 || b.Af != 6 {
+			return nil, nil, fmt.Errorf("-v4 %d is af %d and -v6 %d is af %d, want 4 and 6", a.Id, a.Af, b.Id, b.Af)
+		}
+		if a.Type != b.Type || compare.Recursion(*a) != compare.Recursion(*b) {
+			return nil, nil, fmt.Errorf("-v4 %d is %v %v and -v6 %d is %v %v, want the same",
+				a.Id, a.Type, compare.Recursion(*a), b.Id, b.Type, compare.Recursion(*b))
+		}
+		m := *metro
+		if m == "" {
+			m = compare.Metro(a.Description)
+		}
+		return []compare.Pair{{Metro: m, Kind: a.Type, A: *a, B: *b}}, nil, nil
+	}
+
+	ms, err := results.Search(*tags, *description)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The address family is the side, and the target differs with it, so
+	// measurements pair on their Recursion and Tags.
+	pairs, unpaired := compare.FindPairs(ms, func(m messages.MeasurementResponseMessage) byte {
+		switch m.Af {
+		case 4:
+			return 'A'
+		case 6:
+			return 'B'
+		}
+		return 0
+	}, compare.Recursion)
+	return pairs, unpaired, nil
+}
+
+func main() {
+	flag.Parse()
+
+	if (*v4Id == 0 || *v6Id == 0) && *tags == "" && *description == "" {
+		fmt.Printf("Provide -v4 and -v6 measurement IDs, or -tags/-description to find them.\n")
+		return
+	}
+
+	now := time.Now()
+	var q results.Query
+	var err error
+	if q.Start, err = results.ParseTime(*start, now); err != nil {
+		fmt.Printf("bad -start: %v\n", err)
+		return
+	}
+	if q.Stop, err = results.ParseTime(*stop, now); err != nil {
+		fmt.Printf("bad -stop: %v\n", err)
+		return
+	}
+
+	pairs, unpaired, err := findPairs()
+	if err != nil {
+		fmt.Printf("failed to find measurements to compare: %v\n", err)
+		return
+	}
+	for _, u := range unpaired {
+		fmt.Printf("%v\n", u)
+	}
+	if len(pairs) == 0 {
+		fmt.Printf("No metro has both a v4 and a v6 measurement.\n")
+		return
+	}
+
+	// Positive deltas mean IPv6 is slower, or fails more often, than IPv4.
+	for _, p := range pairs {
+		r, err := compare.Fetch(p, *bucket, &q, "v4", "v6")
+		if err != nil {
+			fmt.Printf("Metro: %v %v failed: %v\n", p.Metro, p.Kind, err)
+			continue
+		}
+		for _, sk := range r.Skipped {
+			fmt.Printf("%v\n", sk)
+		}
+		fmt.Printf("Metro: %v %v v4: %d v6: %d %v\n", p.Metro, p.Kind, p.A.Id, p.B.Id, r)
+		if *verbose {
+			for _, d := range r.Probes {
+				fmt.Printf("\t%v\n", d)
+			}
+		}
+	}
+}
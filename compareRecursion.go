@@ -0,0 +1,134 @@
+// compareRecursion reports the cost of recursion, per metro and per probe,
+// by pairing the recursive (set_rd_bit) and non-recursive measurements made
+// for each metro and address family: gdns_v4 with gdns_v4_norecurse, and
+// gdns_v6 with gdns_v6_norecurse.
+//
+// Measurements are given directly:
+//
+//	go run compareRecursion.go -rd 18811137 -nord 18811139
+//
+// or found by tags and/or description, and paired by the metro named in their
+// description:
+//
+//	go run compareRecursion.go -tags google-public-dns
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/compare"
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/results"
+)
+
+var (
+	rdId        = flag.Int("rd", 0, "Recursive measurement ID to compare.")
+	noRdId      = flag.Int("nord", 0, "Non-recursive measurement ID to compare.")
+	metro       = flag.String("metro", "", "Metro to label a -rd/-nord pair with, defaults to the metro in the description.")
+	tags        = flag.String("tags", "", "Comma separated list of tags to find measurement pairs by.")
+	description = flag.String("description", "", "Text the description of measurement pairs must contain.")
+	bucket      = flag.Duration("bucket", 0, "Time bucket to join results in, defaults to the measurement interval.")
+	start       = flag.String("start", "", "Only results after this time: unix seconds, RFC3339 or relative (-1h).")
+	stop        = flag.String("stop", "", "Only results before this time: unix seconds, RFC3339 or relative (-1h).")
+	verbose     = flag.Bool("verbose", true, "Report each probe, as well as each metro.")
+)
+
+// recursion returns the pair side of a measurement: 'A' for non-recursive,
+// 'B' for recursive.
+func recursion(m messages.MeasurementResponseMessage) byte {
+	switch compare.Recursion(m) {
+	case "non-recursive":
+		return 'A'
+	case "recursive":
+		return 'B'
+	}
+	return 0
+}
+
+// target is the kind of a measurement, so only measurements of the same
+// address family and target are paired.
+func target(m messages.MeasurementResponseMessage) string {
+	return fmt.Sprintf("v%d/%v", m.Af, m.Target)
+}
+
+// findPairs returns the measurement pairs requested on the command-line, and
+// the measurements found which could not be paired.
+func findPairs() ([]compare.Pair, []compare.Unpaired, error) {
+	if *rdId != 0 && *noRdId != 0 {
+		a, err := results.Measurement(*noRdId)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := results.Measurement(*rdId)
+		if err != nil {
+			return nil, nil, err
+		}
+		m := *metro
+		if m == "" {
+			m = compare.Metro(a.Description)
+		}
+		return []compare.Pair{{Metro: m, Kind: a.Type, A: *a, B: *b}}, nil, nil
+	}
+
+	ms, err := results.Search(*tags, *description)
+	if err != nil {
+		return nil, nil, err
+	}
+	pairs, unpaired := compare.FindPairs(ms, recursion, target)
+	return pairs, unpaired, nil
+}
+
+func main() {
+	flag.Parse()
+
+	if (*rdId == 0 || *noRdId == 0) && *tags == "" && *description == "" {
+		fmt.Printf("Provide -rd and -nord measurement IDs, or -tags/-description to find them.\n")
+		return
+	}
+
+	now := time.Now()
+	var q results.Query
+	var err error
+	if q.Start, err = results.ParseTime(*start, now); err != nil {
+		fmt.Printf("bad -start: %v\n", err)
+		return
+	}
+	if q.Stop, err = results.ParseTime(*stop, now); err != nil {
+		fmt.Printf("bad -stop: %v\n", err)
+		return
+	}
+
+	pairs, unpaired, err := findPairs()
+	if err != nil {
+		fmt.Printf("failed to find measurements to compare: %v\n", err)
+		return
+	}
+	for _, u := range unpaired {
+		fmt.Printf("%v\n", u)
+	}
+	if len(pairs) == 0 {
+		fmt.Printf("No metro has both a recursive and a non-recursive measurement.\n")
+		return
+	}
+
+	// Positive deltas are the cost of recursion: the recursive query is
+	// slower, or fails more often, than the non-recursive one.
+	for _, p := range pairs {
+		r, err := compare.Fetch(p, *bucket, &q, "norecurse", "recurse")
+		if err != nil {
+			fmt.Printf("Metro: %v %v failed: %v\n", p.Metro, p.Kind, err)
+			continue
+		}
+		for _, sk := range r.Skipped {
+			fmt.Printf("%v\n", sk)
+		}
+		fmt.Printf("Metro: %v %v norecurse: %d recurse: %d %v\n", p.Metro, p.Kind, p.A.Id, p.B.Id, r)
+		if *verbose {
+			for _, d := range r.Probes {
+				fmt.Printf("\t%v\n", d)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// dnsmsg decodes the DNS wire format messages which RIPE atlas dns results
+// carry, base64 encoded, in their abuf field.
+package dnsmsg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Resource record types and classes used in the analysis of results.
+const (
+	TypeA    = 1
+	TypeNS   = 2
+	TypeTXT  = 16
+	TypeAAAA = 28
+	TypeOPT  = 41
+
+	ClassIN    = 1
+	ClassCHAOS = 3
+
+	// optionNSID is the EDNS0 option code of the name server identifier.
+	optionNSID = 3
+)
+
+var (
+	errShort   = errors.New("message too short")
+	errPointer = errors.New("bad name compression pointer")
+
+	rcodeNames = map[int]string{
+		0: "NOERROR",
+		1: "FORMERR",
+		2: "SERVFAIL",
+		3: "NXDOMAIN",
+		4: "NOTIMP",
+		5: "REFUSED",
+		6: "YXDOMAIN",
+		7: "YXRRSET",
+		8: "NXRRSET",
+		9: "NOTAUTH",
+	}
+	typeNames = map[uint16]string{
+		TypeA:    "A",
+		TypeNS:   "NS",
+		5:        "CNAME",
+		6:        "SOA",
+		12:       "PTR",
+		15:       "MX",
+		TypeTXT:  "TXT",
+		TypeAAAA: "AAAA",
+		TypeOPT:  "OPT",
+	}
+)
+
+// RcodeName returns the mnemonic of a response code, e.g. REFUSED.
+func RcodeName(rcode int) string {
+	if n, ok := rcodeNames[rcode]; ok {
+		return n
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// TypeName returns the mnemonic of a resource record type, e.g. TXT.
+func TypeName(t uint16) string {
+	if n, ok := typeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// Header is the fixed header of a DNS message.
+type Header struct {
+	ID      uint16
+	QR      bool
+	Opcode  int
+	AA      bool
+	TC      bool
+	RD      bool
+	RA      bool
+	Rcode   int
+	QDCount uint16
+	ANCount uint16
+	NSCount uint16
+	ARCount uint16
+}
+
+// Question is a single entry in the question section.
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// RR is a single resource record.
+type RR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+	// msg is the whole message, to resolve compressed names in Data.
+	msg []byte
+	off int
+}
+
+// Message is a decoded DNS message.
+type Message struct {
+	Header
+	Questions  []Question
+	Answers    []RR
+	Authority  []RR
+	Additional []RR
+}
+
+// Decode decodes a base64 encoded message, as found in a result's abuf.
+func Decode(abuf string) (*Message, error) {
+	b, err := base64.StdEncoding.DecodeString(abuf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode abuf: %v", err)
+	}
+	return Parse(b)
+}
+
+// Parse decodes a DNS wire format message.
+func Parse(b []byte) (*Message, error) {
+	if len(b) < 12 {
+		return nil, errShort
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	m := &Message{Header: Header{
+		ID:      binary.BigEndian.Uint16(b),
+		QR:      flags&0x8000 != 0,
+		Opcode:  int(flags>>11) & 0xf,
+		AA:      flags&0x0400 != 0,
+		TC:      flags&0x0200 != 0,
+		RD:      flags&0x0100 != 0,
+		RA:      flags&0x0080 != 0,
+		Rcode:   int(flags & 0xf),
+		QDCount: binary.BigEndian.Uint16(b[4:]),
+		ANCount: binary.BigEndian.Uint16(b[6:]),
+		NSCount: binary.BigEndian.Uint16(b[8:]),
+		ARCount: binary.BigEndian.Uint16(b[10:]),
+	}}
+
+	off := 12
+	for i := 0; i < int(m.QDCount); i++ {
+		name, n, err := readName(b, off)
+		if err != nil {
+			return nil, fmt.Errorf("question %d: %v", i, err)
+		}
+		off = n
+		if off+4 > len(b) {
+			return nil, fmt.Errorf("question %d: %v", i, errShort)
+		}
+		m.Questions = append(m.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[off:]),
+			Class: binary.BigEndian.Uint16(b[off+2:]),
+		})
+		off += 4
+	}
+
+	var err error
+	for _, s := range []struct {
+		name  string
+		count uint16
+		rrs   *[]RR
+	}{
+		{"answer", m.ANCount, &m.Answers},
+		{"authority", m.NSCount, &m.Authority},
+		{"additional", m.ARCount, &m.Additional},
+	} {
+		for i := 0; i < int(s.count); i++ {
+			var rr RR
+			rr, off, err = readRR(b, off)
+			if err != nil {
+				return nil, fmt.Errorf("%v %d: %v", s.name, i, err)
+			}
+			*s.rrs = append(*s.rrs, rr)
+		}
+	}
+
+	// Extended rcodes carry their upper bits in the OPT record's TTL.
+	for _, rr := range m.Additional {
+		if rr.Type == TypeOPT {
+			m.Rcode |= int(rr.TTL>>24) << 4
+		}
+	}
+	return m, nil
+}
+
+// RcodeName returns the mnemonic of the message's response code.
+func (m *Message) RcodeName() string {
+	return RcodeName(m.Rcode)
+}
+
+// NSID returns the name server identifier from the EDNS0 NSID option,
+// if the server included one.
+func (m *Message) NSID() (string, bool) {
+	for _, rr := range m.Additional {
+		if rr.Type != TypeOPT {
+			continue
+		}
+		d := rr.Data
+		for len(d) >= 4 {
+			code := binary.BigEndian.Uint16(d)
+			l := int(binary.BigEndian.Uint16(d[2:]))
+			if len(d) < 4+l {
+				break
+			}
+			if code == optionNSID {
+				return printable(d[4 : 4+l]), true
+			}
+			d = d[4+l:]
+		}
+	}
+	return "", false
+}
+
+// TXT returns the character strings of a TXT record.
+func (rr RR) TXT() []string {
+	var res []string
+	d := rr.Data
+	for len(d) > 0 {
+		l := int(d[0])
+		if len(d) < 1+l {
+			break
+		}
+		res = append(res, string(d[1:1+l]))
+		d = d[1+l:]
+	}
+	return res
+}
+
+// String is the presentation form of the record's data, e.g. an address
+// for A and AAAA records or the quoted strings of a TXT record.
+func (rr RR) String() string {
+	switch rr.Type {
+	case TypeA, TypeAAAA:
+		if len(rr.Data) == net.IPv4len || len(rr.Data) == net.IPv6len {
+			return net.IP(rr.Data).String()
+		}
+	case TypeTXT:
+		var res []string
+		for _, s := range rr.TXT() {
+			res = append(res, fmt.Sprintf("%q", s))
+		}
+		return strings.Join(res, " ")
+	case TypeNS, 5, 12:
+		if name, _, err := readName(rr.msg, rr.off); err == nil {
+			return name
+		}
+	}
+	return fmt.Sprintf("\\# %d %x", len(rr.Data), rr.Data)
+}
+
+func readRR(b []byte, off int) (RR, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return RR{}, 0, err
+	}
+	if off+10 > len(b) {
+		return RR{}, 0, errShort
+	}
+	rr := RR{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(b[off:]),
+		Class: binary.BigEndian.Uint16(b[off+2:]),
+		TTL:   binary.BigEndian.Uint32(b[off+4:]),
+	}
+	l := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if off+l > len(b) {
+		return RR{}, 0, errShort
+	}
+	rr.Data = b[off : off+l]
+	rr.msg = b
+	rr.off = off
+	return rr, off + l, nil
+}
+
+// readName reads a possibly compressed domain name at off, returning the
+// name and the offset following it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	// Bound the pointers followed, so a pointer loop cannot hang the parse.
+	for hops := 0; ; hops++ {
+		if off >= len(b) {
+			return "", 0, errShort
+		}
+		if hops > 255 {
+			return "", 0, errPointer
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errShort
+			}
+			ptr := int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			if ptr >= off {
+				return "", 0, errPointer
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = ptr
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errShort
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// printable returns s as text, hex encoded if it is not printable ASCII.
+func printable(s []byte) string {
+	for _, c := range s {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Sprintf("%x", s)
+		}
+	}
+	return string(s)
+}
+
+// Result decodes the answer of a dns measurement result.
+func Result(m messages.MeasurementResultMessage) (*Message, error) {
+	if m.Failed() {
+		return nil, fmt.Errorf("result failed: %s", m.Error)
+	}
+	if m.Result.Abuf == "" {
+		return nil, errors.New("result has no abuf")
+	}
+	return Decode(m.Result.Abuf)
+}
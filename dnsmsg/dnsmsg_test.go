@@ -0,0 +1,137 @@
+package dnsmsg
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// header is a response header with the given rcode and section counts.
+func header(rcode byte, qd, an, ns, ar byte) []byte {
+	return []byte{0x12, 0x34, 0x81, 0x80 | rcode, 0, qd, 0, an, 0, ns, 0, ar}
+}
+
+// question is the question of id.server. TXT CH, at offset 12 when it
+// follows the header.
+var question = []byte{
+	2, 'i', 'd', 6, 's', 'e', 'r', 'v', 'e', 'r', 0,
+	0, TypeTXT, 0, ClassCHAOS,
+}
+
+func concat(bs ...[]byte) []byte {
+	var res []byte
+	for _, b := range bs {
+		res = append(res, b...)
+	}
+	return res
+}
+
+func TestParseCompression(t *testing.T) {
+	b := concat(header(0, 1, 2, 0, 0), question,
+		// A TXT answer whose owner is a pointer to the question's name.
+		[]byte{0xc0, 12, 0, TypeTXT, 0, ClassCHAOS, 0, 0, 0, 0, 0, 9,
+			3, 'f', 'r', 'a', 4, 'a', 'm', 's', '1'},
+		// An NS answer whose owner is a label followed by a pointer, and
+		// whose data is a pointer to the question's name.
+		[]byte{3, 'n', 's', '1', 0xc0, 15, 0, TypeNS, 0, ClassIN, 0, 0, 0x0e, 0x10, 0, 2,
+			0xc0, 12})
+	m, err := Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if m.ID != 0x1234 || !m.QR || !m.RD || !m.RA || m.Rcode != 0 {
+		t.Errorf("Parse() header = %+v", m.Header)
+	}
+	if len(m.Questions) != 1 || m.Questions[0] != (Question{"id.server.", TypeTXT, ClassCHAOS}) {
+		t.Errorf("Parse() questions = %+v", m.Questions)
+	}
+	if len(m.Answers) != 2 {
+		t.Fatalf("Parse() got %d answers, want 2", len(m.Answers))
+	}
+	txt := m.Answers[0]
+	if txt.Name != "id.server." || txt.String() != `"fra" "ams1"` {
+		t.Errorf("answer 1 = %v %v, want id.server. \"fra\" \"ams1\"", txt.Name, txt.String())
+	}
+	ns := m.Answers[1]
+	if ns.Name != "ns1.server." || ns.TTL != 3600 || ns.String() != "id.server." {
+		t.Errorf("answer 2 = %v %v %v, want ns1.server. 3600 id.server.", ns.Name, ns.TTL, ns.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want error
+	}{
+		{"short header", header(0, 0, 0, 0, 0)[:11], errShort},
+		{"truncated question", concat(header(0, 1, 0, 0, 0), question[:len(question)-1]), errShort},
+		{"truncated label", concat(header(0, 1, 0, 0, 0), []byte{6, 's', 'e'}), errShort},
+		{"truncated pointer", concat(header(0, 1, 0, 0, 0), []byte{0xc0}), errShort},
+		{"forward pointer", concat(header(0, 1, 0, 0, 0), []byte{0xc0, 14, 0, 0, 0, 0}), errPointer},
+		{"pointer to itself", concat(header(0, 1, 0, 0, 0), []byte{0xc0, 12, 0, 0, 0, 0}), errPointer},
+		// A label then a pointer back to it: each pointer is backwards,
+		// but the name never ends.
+		{"pointer loop", concat(header(0, 1, 0, 0, 0), []byte{1, 'a', 0xc0, 12, 0, 1, 0, 1}), errPointer},
+		{"missing answer", concat(header(0, 1, 1, 0, 0), question), errShort},
+		{"truncated RR header", concat(header(0, 1, 1, 0, 0), question,
+			[]byte{0xc0, 12, 0, TypeTXT, 0, ClassCHAOS, 0, 0, 0}), errShort},
+		{"truncated RR data", concat(header(0, 1, 1, 0, 0), question,
+			[]byte{0xc0, 12, 0, TypeTXT, 0, ClassCHAOS, 0, 0, 0, 0, 0, 9, 3, 'f', 'r', 'a'}), errShort},
+	}
+	for _, tc := range tests {
+		m, err := Parse(tc.b)
+		if err == nil {
+			t.Errorf("%v: Parse() = %+v, want an error", tc.name, m)
+			continue
+		}
+		// Errors past the header are wrapped with the section they are in.
+		if err != tc.want && err.Error() != "question 0: "+tc.want.Error() && err.Error() != "answer 0: "+tc.want.Error() {
+			t.Errorf("%v: Parse() error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestParseOPT(t *testing.T) {
+	// BADVERS (16): 0 in the header, 1 in the upper bits of the OPT TTL.
+	opt := []byte{0, 0, TypeOPT, 0x10, 0, 1, 0, 0, 0, 0, 14,
+		0, 10, 0, 2, 0xff, 0xff, // an unknown option before the NSID
+		0, optionNSID, 0, 4, 'f', 'r', 'a', '2'}
+	m, err := Parse(concat(header(0, 1, 0, 0, 1), question, opt))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if m.Rcode != 16 || m.RcodeName() != "RCODE16" {
+		t.Errorf("Parse() rcode = %v %v, want 16", m.Rcode, m.RcodeName())
+	}
+	if nsid, ok := m.NSID(); !ok || nsid != "fra2" {
+		t.Errorf("NSID() = %q, %v, want fra2", nsid, ok)
+	}
+
+	// A binary NSID is hex encoded, and without OPT there is no NSID.
+	opt = []byte{0, 0, TypeOPT, 0x10, 0, 0, 0, 0, 0, 0, 6, 0, optionNSID, 0, 2, 0x01, 0xab}
+	m, err = Parse(concat(header(5, 1, 0, 0, 1), question, opt))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if nsid, ok := m.NSID(); !ok || nsid != "01ab" || m.RcodeName() != "REFUSED" {
+		t.Errorf("NSID() = %q, %v rcode %v, want 01ab REFUSED", nsid, ok, m.RcodeName())
+	}
+	m, err = Parse(concat(header(3, 1, 0, 0, 0), question))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if nsid, ok := m.NSID(); ok || m.RcodeName() != "NXDOMAIN" {
+		t.Errorf("NSID() = %q, %v rcode %v, want none NXDOMAIN", nsid, ok, m.RcodeName())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	b := concat(header(0, 1, 0, 0, 0), question)
+	m, err := Decode(base64.StdEncoding.EncodeToString(b))
+	if err != nil || len(m.Questions) != 1 {
+		t.Errorf("Decode() = %+v, %v, want a question", m, err)
+	}
+	if _, err := Decode("not base64!"); err == nil {
+		t.Errorf("Decode() of bad base64 succeeded, want an error")
+	}
+}
@@ -0,0 +1,88 @@
+// joinMeasurements aligns the results of several measurements by probe and
+// time bucket, printing a table with each measurement side by side: the
+// median latency of ping, dns and http results, the hop count of traceroutes.
+//
+//	go run joinMeasurements.go -mids 3679868,1666834 -bucket 15m
+//	go run joinMeasurements.go -mids 3679868,1666834 -probes 1001 -format csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/compare"
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/results"
+)
+
+var (
+	mids      = flag.String("mids", "", "Comma separated list of measurement IDs to join.")
+	bucket    = flag.Duration("bucket", 0, "Time bucket to align results in, defaults to the longest measurement interval.")
+	probeList = flag.String("probes", "", "Comma separated list of probe ids to join results for.")
+	start     = flag.String("start", "", "Only results after this time: unix seconds, RFC3339 or relative (-1h).")
+	stop      = flag.String("stop", "", "Only results before this time: unix seconds, RFC3339 or relative (-1h).")
+	format    = flag.String("format", "table", "Output format: table or csv.")
+)
+
+func main() {
+	flag.Parse()
+
+	ids, err := results.ParseIds(*mids)
+	if err != nil || len(ids) == 0 {
+		fmt.Printf("Provide a list of measurement IDs to join: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	var q results.Query
+	if q.Start, err = results.ParseTime(*start, now); err != nil {
+		fmt.Printf("bad -start: %v\n", err)
+		return
+	}
+	if q.Stop, err = results.ParseTime(*stop, now); err != nil {
+		fmt.Printf("bad -stop: %v\n", err)
+		return
+	}
+	if q.ProbeIds, err = results.ParseIds(*probeList); err != nil {
+		fmt.Printf("bad -probes: %v\n", err)
+		return
+	}
+
+	var ms []messages.MeasurementResponseMessage
+	var interval int32
+	for _, id := range ids {
+		m, err := results.Measurement(int(id))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		if m.Interval > interval {
+			interval = m.Interval
+		}
+		ms = append(ms, *m)
+	}
+	width := *bucket
+	if width == 0 {
+		width = time.Duration(interval) * time.Second
+	}
+
+	t, err := compare.Join(ms, width, &q)
+	if err != nil {
+		fmt.Printf("failed to join the measurements: %v\n", err)
+		return
+	}
+	// Skipped results go to stderr, so they stay out of csv output.
+	for _, sk := range t.Skipped {
+		fmt.Fprintf(os.Stderr, "%v\n", sk)
+	}
+	switch *format {
+	case "csv":
+		if err := t.WriteCSV(os.Stdout); err != nil {
+			fmt.Printf("failed to write csv: %v\n", err)
+		}
+	default:
+		fmt.Printf("%v\n", t)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -26,11 +27,32 @@ var (
 	mDef   = flag.String("measurement", "", "JSON file with prototype measurement request.")
 	key    = flag.String("apiKey", "", "RipeAtlas API key, a file with a key as text.")
 	count  = flag.Int("count", 5, "How many probes to return upon request.")
-	metro  = flag.String("metro", "IAD", "What metro to constrain probe set to.")
+	metro  = flag.String("metro", "IAD", "What metro to constrain probe set to: a metro, airport (IATA or ICAO) code, or city.")
 	radius = flag.Int("radius", 10, "radius from metro center to constrain probe location.")
-	v4     = flag.Bool("v4", true, "Should the probe have ipv4 addressing?")
-	v6     = flag.Bool("v6", true, "Should the probe have ipv6 addressing?")
-	mType  = flag.String("mType", "dns", "What type of probe request is being requested?")
+	maxRad = flag.Int("maxRadius", 0, "Widen the radius, in -radiusStep km steps, up to this until -count probes are found.")
+	radStp = flag.Int("radiusStep", 0, "Step to widen the radius by, defaults to -radius.")
+	minCnt = flag.Int("minCount", 1, "Fewest probes to schedule a measurement with.")
+	anchor = flag.String("anchors", "", "Anchor selection: prefer (anchors before other probes) or require (only anchors).")
+	mesh   = flag.String("mesh", "", "Comma separated metros to schedule an anchor mesh between, from each metro's anchors to the others'. Only schedules: read the results of the measurement ids printed with measurement-status.")
+	afMode = flag.String("af", probes.V4Capable, "Address families probes must work over: v4-capable, v6-capable, dual-stack, v4-only or v6-only.")
+	v4     = flag.Bool("v4", true, "Deprecated, use -af: should the probe have ipv4 addressing?")
+	v6     = flag.Bool("v6", true, "Deprecated, use -af: should the probe have ipv6 addressing?")
+	geo    = flag.String("geocoder", "airports", "How to locate the metro: airports, kelvins or static.")
+	geoKey = flag.String("geocodingKey", "", "File with a google geocoding API key, defaults to $GEOCODING_API_KEY.")
+	geoFb  = flag.Bool("geocode", false, "Geocode the metro's city when its airport has no location.")
+	locs   = flag.String("locations", "", "CSV file of metro,lat,long for the static geocoder.")
+	mDb    = flag.String("metros", "", "CSV file of metros to add to, or replace, the bundled metros: code,city,country,lat,long,airports")
+	apDir  = flag.String("airportsDir", probes.Airports.Dir, "Directory to cache the airports data in.")
+	apAge  = flag.Duration("airportsMaxAge", probes.Airports.MaxAge, "Age after which the cached airports data is refreshed.")
+	sel    = flag.String("select", "id", "How to choose among the probes found: id, nearest, stable, random, asn or prefix (most networks).")
+	seed   = flag.Int64("seed", 1, "Seed for the random selection.")
+	uptime = flag.Float64("minUptime", 0, "Smallest fraction of the time since it first connected a probe must have been connected.")
+	since  = flag.Duration("minConnected", 0, "How long a probe must have been connected.")
+	pTags  = flag.String("probeTags", "", "Comma separated tags probes must have, e.g. system-ipv6-works.")
+	pSet   = flag.String("probe-set", "", "Named probe set to measure from, created from the probes located when it does not exist.")
+	pDir   = flag.String("probeSetDir", probes.SetsDir(), "Directory probe sets are saved in.")
+	snap   = flag.String("snapshot", "", "Probe snapshot file (see probeSnapshot) to select probes from, instead of RIPE.")
+	mType  = flag.String("mType", "", "Measurement type, defaults to the type of the -measurement template, which it must match.")
 
 	// by default the timespan of a measurement is 24h
 	measurementDuration = flag.Duration("md", 24*time.Hour, "measurement duration")
@@ -109,7 +131,7 @@ func formatRequest(r *http.Request) string {
 func measurementGroom(m *messages.MeasurementRequest, probeIds []string, metro *string, md *time.Duration) {
 	// Create a probes message to add to the measurement.
 	m.Probe_Source = append(m.Probe_Source, messages.ProbeSourceMessage{
-		Requested: int32(*count),
+		Requested: int32(len(probeIds)),
 		Type:      "probes",
 		Value:     strings.Join(probeIds, ","),
 	})
@@ -154,13 +176,17 @@ func restfulRequest(m *messages.MeasurementRequest, k string, au map[string]stri
 	if err != nil {
 		return fmt.Errorf("failed to make measurement request:\n%v\n", err)
 	}
+	defer resp.Body.Close()
 
 	// Inbound 'rt' is the json struct to fill with response data, fill it now.
 	c, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read response body: %v", err)
 	}
-	defer resp.Body.Close()
+	// Failed requests are answered with the API's error, not a measurement.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("measurement request failed(%v): %s", resp.Status, bytes.TrimSpace(c))
+	}
 
 	err = json.Unmarshal(c, rt)
 	if err != nil {
@@ -170,6 +196,213 @@ func restfulRequest(m *messages.MeasurementRequest, k string, au map[string]stri
 	return nil
 }
 
+// checkType sets -mType, which picks the API URL to request, from the type of
+// the measurement template, and rejects a -mType which differs from it.
+func checkType(m *messages.MeasurementRequest) error {
+	if len(m.Definitions) == 0 {
+		return fmt.Errorf("the measurement template has no definitions")
+	}
+	t := m.Definitions[0].Type
+	given := false
+	flag.Visit(func(f *flag.Flag) { given = given || f.Name == "mType" })
+	if given && *mType != t {
+		return fmt.Errorf("-mType(%v) differs from the measurement template's type(%v)", *mType, t)
+	}
+	if _, ok := atlasURLs[t]; !ok || t == "results" || t == "key" {
+		return fmt.Errorf("unknown measurement type(%v) in the measurement template", t)
+	}
+	*mType = t
+	return nil
+}
+
+// locateProbes returns the ids of the probes to measure from, and their
+// metro. A probe set which exists is reused, otherwise the probes are
+// located by the criteria, and saved when a probe set is named.
+func locateProbes(g probes.Geocoder, c probes.Criteria) ([]int32, string, error) {
+	if *pSet == "" {
+		sel, err := probes.LocateProbes(g, c)
+		if err != nil {
+			return nil, "", err
+		}
+		fmt.Printf("Found probes within: %d km\n", sel.Radius)
+		return sel.Ids(), c.Metro, nil
+	}
+
+	set, err := probes.LoadSet(*pDir, *pSet)
+	if err == nil {
+		if err := setConflicts(c, set); err != nil {
+			return nil, "", err
+		}
+		sc := set.Criteria
+		fmt.Printf("Using probe set: %v updated: %v\n", set.Name, set.Updated.Format(time.RFC3339))
+		fmt.Printf("\tlocated by its own criteria, metro: %v radius: %d count: %d af: %v anchors: %v select: %v\n",
+			sc.Metro, sc.Radius, sc.Count, sc.AF, sc.Anchors, sc.Select)
+		return set.Probes, sc.Metro, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	if set, err = probes.NewSet(g, *pSet, c); err != nil {
+		return nil, "", err
+	}
+	if err := set.Save(*pDir); err != nil {
+		return nil, "", fmt.Errorf("failed to save the probe set: %v", err)
+	}
+	fmt.Printf("Saved probe set: %v found within: %d km\n", set.Name, set.Radius)
+	return set.Probes, c.Metro, nil
+}
+
+// setCriteria maps the flags which set probe criteria to the criteria names
+// of probes.Criteria.Differences.
+var setCriteria = map[string]string{
+	"metro":        "metro",
+	"radius":       "radius",
+	"maxRadius":    "max_radius",
+	"radiusStep":   "radius_step",
+	"count":        "count",
+	"minCount":     "min_count",
+	"af":           "af",
+	"v4":           "af",
+	"v6":           "af",
+	"anchors":      "anchors",
+	"minUptime":    "stability",
+	"minConnected": "stability",
+	"probeTags":    "stability",
+	"select":       "select",
+	"seed":         "seed",
+}
+
+// setConflicts returns an error when a criteria flag given on the command
+// line differs from the criteria a saved probe set was located with, as the
+// set's probes would be used regardless.
+func setConflicts(c probes.Criteria, set *probes.ProbeSet) error {
+	differ := map[string]bool{}
+	for _, d := range c.Differences(set.Criteria) {
+		differ[d] = true
+	}
+	var conflicts []string
+	flag.Visit(func(f *flag.Flag) {
+		if differ[setCriteria[f.Name]] {
+			conflicts = append(conflicts, "-"+f.Name)
+		}
+	})
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%v differ from the criteria probe set(%v) was located with: drop them, or name a new set",
+			strings.Join(conflicts, ", "), set.Name)
+	}
+	return nil
+}
+
+// scheduleMesh finds the anchors of each metro, then schedules a measurement
+// from the anchors of each metro toward each anchor of every other metro,
+// and reports the metro to metro matrix of measurements scheduled, also
+// when scheduling fails partway. Anchors must work over the address family
+// of the measurement template, whatever the -af mode.
+func scheduleMesh(g probes.Geocoder, c probes.Criteria, metros []string, apiKey string) error {
+	proto, err := readJson(mDef)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal the JSON measurementRequest: %v", err)
+	}
+	af := int(proto.Definitions[0].AF)
+	works := false
+	for _, a := range probes.ModeAFs(c.AF) {
+		works = works || a == af
+	}
+	switch {
+	case works:
+	case af == 6:
+		c.AF = probes.V6Capable
+	default:
+		c.AF = probes.V4Capable
+	}
+
+	anchors := map[string][]messages.ProbeMessage{}
+	c.Anchors = "require"
+	for _, m := range metros {
+		c.Metro = m
+		sel, err := probes.LocateProbes(g, c)
+		if err != nil {
+			return fmt.Errorf("failed to find the anchors of %v: %v", m, err)
+		}
+		anchors[m] = sel.Probes
+		fmt.Printf("For metro: %v found %d anchors within: %d km\n", m, len(sel.Probes), sel.Radius)
+	}
+
+	matrix := map[string]map[string][]int32{}
+	defer func() {
+		fmt.Println("Anchor mesh measurements scheduled:")
+		for _, from := range metros {
+			for _, to := range metros {
+				if ids, ok := matrix[from][to]; ok {
+					fmt.Printf("\tFrom: %v to: %v measurements: %v\n", from, to, ids)
+				}
+			}
+		}
+	}()
+	for _, from := range metros {
+		var probeIds []string
+		for _, a := range anchors[from] {
+			probeIds = append(probeIds, fmt.Sprintf("%d", a.Id))
+		}
+		if len(probeIds) == 0 {
+			continue
+		}
+		matrix[from] = map[string][]int32{}
+		for _, to := range metros {
+			if to == from {
+				continue
+			}
+			for _, a := range anchors[to] {
+				// Each measurement starts from a fresh copy of the prototype.
+				measurement, err := readJson(mDef)
+				if err != nil {
+					return fmt.Errorf("failed to unmarshal the JSON measurementRequest: %v", err)
+				}
+				target := a.AddressV4
+				if af == 6 {
+					target = a.AddressV6
+				}
+				if target == "" {
+					continue
+				}
+				measurement.Definitions[0].Target = target
+				label := fmt.Sprintf("%v to %v anchor %d", from, to, a.Id)
+				measurementGroom(measurement, probeIds, &label, measurementDuration)
+
+				var results messages.MeasurementResponse
+				if err := restfulRequest(measurement, apiKey, atlasURLs, mType, &results); err != nil {
+					return fmt.Errorf("failed to schedule %v: %v", label, err)
+				}
+				matrix[from][to] = append(matrix[from][to], results.Measurements...)
+			}
+		}
+	}
+	return nil
+}
+
+// addressFamily returns the -af mode, or the mode of the deprecated -v4 and
+// -v6 flags when either is set instead. Both default to true, so either
+// alone is dual-stack: only -v4=false or -v6=false selects a single family.
+func addressFamily() (string, error) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["v4"] && !set["v6"] {
+		return *afMode, nil
+	}
+	if set["af"] {
+		return "", fmt.Errorf("-v4 and -v6 are deprecated, use -af alone")
+	}
+	switch {
+	case *v4 && *v6:
+		return probes.DualStack, nil
+	case *v4:
+		return probes.V4Only, nil
+	case *v6:
+		return probes.V6Only, nil
+	}
+	return "", fmt.Errorf("probes need -v4 or -v6 addressing")
+}
+
 func main() {
 	flag.Parse()
 
@@ -233,33 +466,79 @@ func main() {
 		fmt.Printf("Returned key response: %v\n", *rt)
 		return
 	}
-	// If the measurement type is invalid, stop now.
-	if _, ok := atlasURLs[*mType]; !ok {
-		fmt.Printf("please select an appropriate measurement type (%v) is invalid.\n", *mType)
-		return
-	}
-
 	measurement, err := readJson(mDef)
 	if err != nil {
 		fmt.Printf("failed to unmarshal the JSON measurementRequest: %v\n", err)
 		return
 	}
+	// If the measurement type is invalid, stop now.
+	if err := checkType(measurement); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
 
 	// Locate the probe set to be used in the measurement.
-	probes, err := probes.LocateProbes(metro, radius, count, v4, v6)
+	probes.Airports.Dir = *apDir
+	probes.Airports.MaxAge = *apAge
+	if *snap != "" {
+		if err := probes.UseSnapshot(*snap); err != nil {
+			fmt.Printf("failed to load the probe snapshot: %v\n", err)
+			return
+		}
+	}
+	if *mDb != "" {
+		if err := probes.Metros.Load(*mDb); err != nil {
+			fmt.Printf("failed to load the metros: %v\n", err)
+			return
+		}
+	}
+	g, err := probes.NewGeocoder(*geo, *geoKey, *locs, *geoFb)
+	if err != nil {
+		fmt.Printf("failed to setup the geocoder: %v\n", err)
+		return
+	}
+	af, err := addressFamily()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	stability := &probes.Stability{MinUptime: *uptime, MinConnected: *since}
+	if *pTags != "" {
+		stability.Tags = strings.Split(*pTags, ",")
+	}
+	c := probes.Criteria{
+		Metro:      *metro,
+		Radius:     *radius,
+		MaxRadius:  *maxRad,
+		RadiusStep: *radStp,
+		Count:      *count,
+		MinCount:   *minCnt,
+		AF:         af,
+		Anchors:    *anchor,
+		Stability:  stability,
+		Select:     *sel,
+		Seed:       *seed,
+	}
+	if *mesh != "" {
+		if err := scheduleMesh(g, c, strings.Split(*mesh, ","), apiKey); err != nil {
+			fmt.Printf("anchor mesh failed: %v\n", err)
+		}
+		return
+	}
+	ids, m, err := locateProbes(g, c)
 	if err != nil {
 		fmt.Printf("probe gathering failed: %v\n", err)
 		return
 	}
 
 	var probeIds []string
-	for _, p := range probes {
-		probeIds = append(probeIds, fmt.Sprintf("%d", p.Id))
+	for _, id := range ids {
+		probeIds = append(probeIds, fmt.Sprintf("%d", id))
 	}
-	fmt.Printf("For metro: %v found %v probes.\n", *metro, len(probeIds))
+	fmt.Printf("For metro: %v found %v probes.\n", m, len(probeIds))
 
 	// Clean up the measurement, add duration and probes.
-	measurementGroom(measurement, probeIds, metro, measurementDuration)
+	measurementGroom(measurement, probeIds, &m, measurementDuration)
 
 	var results messages.MeasurementResponse
 	err = restfulRequest(measurement, apiKey, atlasURLs, mType, &results)
@@ -11,23 +11,35 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/morrowc/ripe-atlas/compare"
 	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/ntp"
 	"github.com/morrowc/ripe-atlas/probes"
+	"github.com/morrowc/ripe-atlas/results"
+	"github.com/morrowc/ripe-atlas/slo"
+	"github.com/morrowc/ripe-atlas/stats"
 )
 
 var (
-	mid     = flag.Int("mid", 0, "Measurement ID to get results for.")
-	msmtUrl = "https://atlas.ripe.net/api/v2/measurements/%d/"
+	mid       = flag.Int("mid", 0, "Measurement ID to get results for.")
+	start     = flag.String("start", "", "Only results after this time: unix seconds, RFC3339 or relative (-1h).")
+	stop      = flag.String("stop", "", "Only results before this time: unix seconds, RFC3339 or relative (-1h).")
+	probeList = flag.String("probes", "", "Comma separated list of probe ids to get results for.")
+	latest    = flag.Bool("latest", false, "Get only the latest result from each probe.")
+	bucket    = flag.Duration("bucket", 0, "Time bucket width for the time series, defaults to the measurement interval.")
+	series    = flag.String("series", "measurement", "Time series to report: measurement, probe, all or none.")
+	detect    = flag.Bool("detect", true, "Report sustained latency shifts and error spikes in the time series.")
+	sustain   = flag.Int("sustain", stats.DefaultDetector.Sustain, "Buckets a shift must last to be reported.")
+	shift     = flag.Float64("shift", stats.DefaultDetector.LatencyShift, "Smallest latency shift (ms) to report.")
+	groupBy   = flag.String("group", "country,asn", "Comma separated probe attributes to group statistics by: country, asn, prefix.")
+	sloFile   = flag.String("slo", "", "JSON file of objectives to evaluate the results against, exits 2 on any violation.")
 )
 
 func prettyPrint(ips []string) string {
@@ -39,117 +51,208 @@ func prettyPrint(ips []string) string {
 	return strings.Join(res, "\n\t")
 }
 
-// readStream reads a json stream, report stats as reading continues.
-func readStream(r *bufio.Reader, ch chan messages.MeasurementResultMessage, ic chan int32) {
-	c := json.NewDecoder(r)
-	// Read the first open bracket, don't bother to save the bracket.
-	_, err := c.Token()
-	if err != nil {
-		log.Fatalf("reading the initial token failed: %v", err)
-	}
-
-	// Now, read each record and decode that record.
-	for c.More() {
-		// Create a new record in each iteration.
-		var m messages.MeasurementResultMessage
-		// decode the record. if EOF, exit and close the channel.
-		if err := c.Decode(&m); err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatalf("*** failed decoding a record: %v", err)
-		}
-		// Send the measurement data down the channel.
-		ic <- m.PrbId
-		ch <- m
+// firstRtt returns the latency of the first sample in a result, if any.
+func firstRtt(rec messages.MeasurementResultMessage) float64 {
+	if s := stats.Samples(rec); len(s) > 0 {
+		return s[0].Rtt
 	}
-	close(ch)
+	return 0
 }
 
 func main() {
 	// Parse flags, to get command-line requested information.
 	flag.Parse()
 
-	resurl := fmt.Sprintf(msmtUrl, *mid)
-	fmt.Printf("Url: %s\n", resurl)
-
-	// Make a request that gets the measurement details..
-	response, err := http.Get(resurl)
-	if err != nil {
-		fmt.Printf("Failed to Get url: %v Status: %v", resurl, response.Status)
+	// Build the results query from the time window and probe selection.
+	now := time.Now()
+	var q results.Query
+	var err error
+	if q.Start, err = results.ParseTime(*start, now); err != nil {
+		log.Fatalf("bad -start: %v", err)
+	}
+	if q.Stop, err = results.ParseTime(*stop, now); err != nil {
+		log.Fatalf("bad -stop: %v", err)
+	}
+	if q.ProbeIds, err = results.ParseIds(*probeList); err != nil {
+		log.Fatalf("bad -probes: %v", err)
+	}
+	q.Latest = *latest
+	if err := q.Check(); err != nil {
+		log.Fatalf("bad -latest: %v", err)
+	}
+	if w := stats.DefaultDetector.Window; *sustain < 1 || *sustain > w {
+		log.Fatalf("bad -sustain(%d): must be between 1 and the baseline window, %d buckets", *sustain, w)
 	}
-	defer response.Body.Close()
 
-	// Read all of the details (this is minimal data volume, kbytes)
-	c, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		fmt.Printf("Failed to read contents.\n")
+	var objectives *slo.Config
+	if *sloFile != "" {
+		if objectives, err = slo.Load(*sloFile); err != nil {
+			log.Fatalf("bad -slo: %v", err)
+		}
 	}
 
-	var m messages.MeasurementResponseMessage
-	// Read a stream of json, decode as the reading continues.
-	err = json.Unmarshal(c, &m)
+	m, err := results.Measurement(*mid)
 	if err != nil {
-		fmt.Printf("failed to unmarshal the response to struct: %v\n", err)
+		log.Fatalf("failed to get the measurement details: %v", err)
 	}
 
 	// print just the resolved_ips
 	fmt.Printf("IP addresses polled in this measurement(%d):\n\t%s\n",
 		*mid, prettyPrint(m.ResolvedIps))
 	fmt.Printf("Results are at:\n%v\n", m.Result)
+	fmt.Printf("Fetching: %v\n", q.URL(*mid))
 
-	// Build infrastructure to gather all result data next, in a pipelined manner.
-	//
-	// Make channels for other routines to use, message pipelines.
-	// ch - measurement results.
-	// ic - probe-id channel
-	// oc - probe results channel
-	ch := make(chan messages.MeasurementResultMessage, 100)
-	ic := make(chan int32, 10)
-	oc := make(chan messages.ProbeMessage, 10)
-
-	// Request results, send successful results body to the reader.
-	response, err = http.Get(m.Result)
+	// Request results, the iterator decodes them as the body is read.
+	it, err := results.Fetch(*mid, &q)
 	if err != nil {
-		log.Fatalf("failed to read the results(%v): %v\n", m.Result, err)
+		log.Fatalf("failed to read the results: %v\n", err)
 	}
-	defer response.Body.Close()
+	defer it.Close()
 
-	// Start a routine to query for probe data.
-	go probes.GatherProbe(ic, oc)
-
-	// Start the reader routine, read from the channel c records returned.
-	go readStream(bufio.NewReader(response.Body), ch, ic)
+	// Read the results, report as reading continues. Probe ids seen for the
+	// first time are collected to query for probe details afterwards.
+	var prbIds []int32
+	seen := map[int32]bool{}
+	ntpSummary := ntp.New()
+	latency := stats.NewCollector()
+	width := *bucket
+	if width == 0 {
+		width = time.Duration(m.Interval) * time.Second
+	}
+	timeSeries := stats.NewTimeSeries(width)
+	var evaluator *slo.Evaluator
+	if objectives != nil {
+		evaluator = slo.NewEvaluator(objectives)
+	}
+	err = it.Each(func(rec messages.MeasurementResultMessage) {
+		if !seen[rec.PrbId] {
+			seen[rec.PrbId] = true
+			prbIds = append(prbIds, rec.PrbId)
+		}
 
-	// Read the probe stream, add items to the probe map.
-	go func() {
-		for m := range oc {
-			probes.Probes[m.Id] = m
+		latency.Add(rec)
+		timeSeries.Add(rec)
+		if evaluator != nil {
+			evaluator.Add(rec)
 		}
-	}()
 
-	// Read the measurementresultsmessage channel, report results.
-	var rttNum []float64
-	for rec := range ch {
 		switch rec.Type {
 		case "http":
-			fmt.Printf("Http result - Rt: %v\n", rec.Result.Rt)
+			fmt.Printf("Http result - Rt: %v\n", firstRtt(rec))
 		case "ping":
-			fmt.Printf("Ping result - Rtt: %v\n", rec.Result.Rtt)
+			fmt.Printf("Ping result - Avg: %v Sent: %d Rcvd: %d\n", rec.Avg, rec.Sent, rec.Rcvd)
 		case "traceroute":
-			fmt.Printf("Traceroute result - Rtt: %v\n", rec.Result.Rtt)
+			fmt.Printf("Traceroute result - Rtt: %v Hops: %d\n",
+				firstRtt(rec), len(rec.Result.Entries))
 		case "dns":
-			fmt.Printf("Traceroute result - Rtt: %v\n", rec.Result.Rt)
-			rttNum = append(rttNum, rec.Result.Rt)
+			fmt.Printf("Dns result - Rt: %v\n", rec.Result.Rt)
+		case "ntp":
+			fmt.Printf("Ntp result - Stratum: %v Ref-id: %v Packets: %d\n",
+				rec.Stratum, rec.RefId, len(rec.Result.Entries))
+			ntpSummary.Add(rec)
 		default:
 			fmt.Printf("No idea what type: %v\n", rec.Type)
 		}
-		fmt.Printf("Max num of probes so far: %d\n", len(probes.Probes))
-		fmt.Printf("Num countries for probes: %d\n", len(probes.Countries))
+		fmt.Printf("Max num of probes so far: %d\n", len(seen))
+	}, func(de *results.DecodeError) {
+		fmt.Printf("Skipping result: %v\n", de)
+	})
+	if err != nil {
+		log.Fatalf("failed reading the results: %v", err)
+	}
+
+	// Gather details about the probes seen in the results.
+	details, err := probes.Lookup(prbIds)
+	if err != nil {
+		log.Fatalf("failed to look up the probes: %v", err)
+	}
+	countries := map[string]int{}
+	for _, p := range details {
+		countries[p.CountryCode]++
+	}
+	fmt.Printf("Num probes: %d\n", len(details))
+	fmt.Printf("Num countries for probes: %d\n", len(countries))
+
+	// Report the latency statistics, for the measurement and each probe.
+	fmt.Printf("Latency(ms) %v\n", latency.All.Summary())
+	for _, id := range latency.ProbeIds() {
+		fmt.Printf("\tProbe: %d %v\n", id, latency.Probes[id].Summary())
+	}
+
+	// Report the latency statistics grouped by each probe attribute requested.
+	for _, attr := range strings.Split(*groupBy, ",") {
+		if attr == "" {
+			continue
+		}
+		if _, err := probes.Attribute(messages.ProbeMessage{}, attr, m.Af); err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		fmt.Printf("Latency(ms) by %v:\n", attr)
+		for _, g := range latency.GroupBy(func(id int32) string {
+			p, ok := details[id]
+			if !ok {
+				return ""
+			}
+			k, _ := probes.Attribute(p, attr, m.Af)
+			return k
+		}) {
+			fmt.Printf("\t%v probes: %d %v\n", g.Key, len(g.Probes), g.Values.Summary())
+		}
+	}
+
+	// Report the time series, for the measurement and/or each probe.
+	if *series == "measurement" || *series == "all" {
+		fmt.Printf("Time series(%v):\n", timeSeries.All.Width())
+		for _, b := range timeSeries.All.Buckets() {
+			fmt.Printf("\t%v\n", b)
+		}
+	}
+	if *series == "probe" || *series == "all" {
+		for _, id := range timeSeries.ProbeIds() {
+			fmt.Printf("Time series(%v) probe: %d\n", timeSeries.All.Width(), id)
+			for _, b := range timeSeries.Probes[id].Buckets() {
+				fmt.Printf("\t%v\n", b)
+			}
+		}
+	}
+
+	// Report sustained shifts, for the measurement and the probes affected,
+	// then those of each probe.
+	if *detect {
+		d := stats.DefaultDetector
+		d.Sustain = *sustain
+		d.LatencyShift = *shift
+		var probeEvents []stats.Event
+		for _, id := range timeSeries.ProbeIds() {
+			probeEvents = append(probeEvents, d.Detect(id, timeSeries.Probes[id].Buckets())...)
+		}
+		fmt.Printf("Anomalies:\n")
+		for _, e := range d.Detect(0, timeSeries.All.Buckets()) {
+			fmt.Printf("\t%v affected probes: %v\n", e,
+				stats.Affected(e, probeEvents, time.Duration(d.Sustain)*timeSeries.All.Width()))
+		}
+		for _, e := range probeEvents {
+			fmt.Printf("\t%v\n", e)
+		}
+	}
 
-		var total float64 = 0
-		for _, val := range rttNum {
-			total += val
+	// Report the NTP offset distribution, and the probes seeing outliers.
+	if len(ntpSummary.Probes) > 0 {
+		ntpSummary.Summarize()
+		fmt.Printf("%v\n", ntpSummary)
+	}
+
+	// Evaluate the objectives last, so their result is the exit status.
+	if evaluator != nil {
+		violations := evaluator.Evaluate(*m, compare.Metro(m.Description), details)
+		fmt.Printf("SLO violations: %d\n", len(violations))
+		for _, v := range violations {
+			fmt.Printf("\t%v\n", v)
+		}
+		if len(violations) > 0 {
+			it.Close()
+			os.Exit(2)
 		}
-		fmt.Printf("Avg rtt: %0.5f\n", total/float64(len(rttNum)))
 	}
 }
@@ -2,12 +2,18 @@
 // the RIPE atlas system.
 package messages
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
 // MultiMeasurementRespnseMessage is returned when querying for
 // measurements instead of requesting a direct measurement.
 type MultiMeasurementResponseMessage struct {
 	Count    int32                        `json:"count"`
-	Next     int32                        `json:"next"`
-	Previous int32                        `json:"previous"`
+	Next     string                       `json:"next"` // URL of the next page, if any
+	Previous string                       `json:"previous"`
 	Results  []MeasurementResponseMessage `json:"results"`
 }
 
@@ -38,6 +44,7 @@ type MeasurementResponseMessage struct {
 	StartTime        int32          `json:"start_time"`
 	Status           ResponseStatus `json:"status"`
 	StopTime         int32          `json:"stop_time"`
+	Tags             []string       `json:"tags"`
 	Target           string         `json:"target"`
 	TargetAsn        int32          `json:"target_asn"`
 	TargetIp         string         `json:"target_ip"`
@@ -81,14 +88,40 @@ type MeasurementResultMessage struct {
 	Ttl       int     `json:"ttl"`
 	Type      string  `json:"type"`
 	Uri       string  `json:"uri"`
+
+	// Error is set when the measurement failed outright, for example a dns
+	// query which timed out: {"timeout": 5000}.
+	Error json.RawMessage `json:"error"`
+
+	// NTP specific fields, describing the server's clock.
+	Li             string  `json:"li"` // leap indicator: no, 59, 61 or unknown
+	Mode           string  `json:"mode"`
+	Poll           int     `json:"poll"`
+	Precision      float64 `json:"precision"`
+	RefId          string  `json:"ref-id"`
+	RefTs          float64 `json:"ref-ts"`
+	RootDelay      float64 `json:"root-delay"`
+	RootDispersion float64 `json:"root-dispersion"`
+	Stratum        int     `json:"stratum"`
+	Version        int     `json:"version"`
+}
+
+// Failed reports whether the result carries an error rather than an answer.
+func (m *MeasurementResultMessage) Failed() bool {
+	return len(m.Error) > 0 && string(m.Error) != "null"
+}
+
+// TimedOut reports whether the result failed due to a timeout.
+func (m *MeasurementResultMessage) TimedOut() bool {
+	return m.Failed() && bytes.Contains(m.Error, []byte(`"timeout"`))
 }
 
 // ProbeQueryResults is the JSON struct returned by a ripe
 // /?probes request.
 type ProbeQueryResults struct {
 	Count    int            `json:"count"`
-	Next     int            `json:"next"`
-	Previous int            `json:"previous"`
+	Next     string         `json:"next"` // URL of the next page, if any
+	Previous string         `json:"previous"`
 	Results  []ProbeMessage `json:"results"`
 }
 
@@ -104,6 +137,85 @@ type Results struct {
 	SrcAddr string  `json:"src_addr"`
 	Ver     string  `json:"ver"`
 	Rtt     float64 `json:"rtt"`
+
+	// DNS specific, the answer as a base64 encoded DNS message and the
+	// counts of its sections.
+	Abuf    string `json:"abuf"`
+	ANCount int    `json:"ANCOUNT"`
+	ARCount int    `json:"ARCOUNT"`
+	ID      int    `json:"ID"`
+	NSCount int    `json:"NSCOUNT"`
+	QDCount int    `json:"QDCOUNT"`
+
+	// Entries holds the per-packet results for measurement types (ping, ntp,
+	// http, traceroute) which return a list rather than a single result object.
+	Entries []ResultEntry `json:"-"`
+}
+
+// UnmarshalJSON decodes a result which is either a single object or,
+// for ping, ntp, http and traceroute measurements, a list of entries.
+func (r *Results) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, &r.Entries)
+	}
+	// Decode through an alias type, to avoid recursing back into this method.
+	type results Results
+	return json.Unmarshal(b, (*results)(r))
+}
+
+// ResultEntry is a single packet's result in a list of results, or for
+// traceroute a single hop with the replies received at that hop.
+// A timed out packet has X set to "*".
+type ResultEntry struct {
+	Rtt   float64 `json:"rtt"`
+	X     string  `json:"x"`
+	Error string  `json:"error"`
+	From  string  `json:"from"`
+	Size  int     `json:"size"`
+	Ttl   int     `json:"ttl"`
+
+	// HTTP specific response details. Err is also set on traceroute
+	// replies which are ICMP errors.
+	Rt  float64 `json:"rt"`
+	Res int     `json:"res"`
+	Err ErrText `json:"err"`
+
+	// Traceroute specific, the hop number and replies at that hop.
+	Hop     int           `json:"hop"`
+	Replies []ResultEntry `json:"result"`
+
+	// NTP specific timestamps and clock offset, all in seconds.
+	FinalTs    float64 `json:"final-ts"`
+	Offset     float64 `json:"offset"`
+	OriginTs   float64 `json:"origin-ts"`
+	ReceiveTs  float64 `json:"receive-ts"`
+	TransmitTs float64 `json:"transmit-ts"`
+}
+
+// Timeout reports whether the packet received no answer.
+func (e ResultEntry) Timeout() bool {
+	return e.X == "*"
+}
+
+// ErrText is the err of a result entry: the error text of an http result,
+// or for a traceroute reply the ICMP unreachable code, which RIPE sends as
+// a letter (N, H, A, P) or, for other codes, a number.
+type ErrText string
+
+// UnmarshalJSON accepts either a string or a number.
+func (e *ErrText) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*e = ErrText(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("err is neither a string nor a number: %s", b)
+	}
+	*e = ErrText(n.String())
+	return nil
 }
 
 // ProbeMessage is the JSON struct returned by a ripe probe query.
@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTracerouteResults(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/traceroute.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []MeasurementResultMessage
+	if err := json.Unmarshal(body, &ms); err != nil {
+		t.Fatalf("failed to decode the traceroutes: %v", err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("decoded %d traceroutes, want 2", len(ms))
+	}
+
+	// The first reaches its destination at hop 4.
+	hops := ms[0].Result.Entries
+	if len(hops) != 4 || hops[3].Hop != 4 || hops[3].Replies[0].From != ms[0].DstAddr {
+		t.Errorf("traceroute 1: got %d hops, last %+v, want 4 ending at %v", len(hops), hops[len(hops)-1], ms[0].DstAddr)
+	}
+	if !hops[1].Replies[0].Timeout() {
+		t.Errorf("traceroute 1 hop 2: got %+v, want a timeout", hops[1].Replies[0])
+	}
+
+	// The second is unreachable: ICMP errors as a letter then a number, and
+	// the final hop 255 without replies.
+	hops = ms[1].Result.Entries
+	if len(hops) != 4 || hops[3].Hop != 255 {
+		t.Fatalf("traceroute 2: got %d hops, want 4 ending at hop 255", len(hops))
+	}
+	for _, tc := range []struct {
+		hop  int
+		want ErrText
+	}{
+		{0, ""},
+		{1, "N"},
+		{2, "1"},
+	} {
+		if got := hops[tc.hop].Replies[0].Err; got != tc.want {
+			t.Errorf("traceroute 2 hop %d: err = %q, want %q", hops[tc.hop].Hop, got, tc.want)
+		}
+	}
+}
+
+func TestErrText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want ErrText
+	}{
+		{`{"err": "connect: Connection refused"}`, "connect: Connection refused"},
+		{`{"err": "H"}`, "H"},
+		{`{"err": 13}`, "13"},
+		{`{"err": null}`, ""},
+		{`{}`, ""},
+	} {
+		var e ResultEntry
+		if err := json.Unmarshal([]byte(tc.in), &e); err != nil {
+			t.Errorf("Unmarshal(%v) failed: %v", tc.in, err)
+			continue
+		}
+		if e.Err != tc.want {
+			t.Errorf("Unmarshal(%v): err = %q, want %q", tc.in, e.Err, tc.want)
+		}
+	}
+	var e ResultEntry
+	if err := json.Unmarshal([]byte(`{"err": true}`), &e); err == nil {
+		t.Errorf("Unmarshal of a boolean err succeeded, want an error")
+	}
+}
@@ -0,0 +1,173 @@
+// ntp summarizes NTP measurement results, per probe and across a measurement.
+package ntp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+const (
+	// outlierFactor is the number of median absolute deviations a probe's
+	// median offset may stray from the measurement median before it is flagged.
+	outlierFactor = 3.0
+	// minOutlier is the smallest offset deviation (seconds) flagged as an
+	// outlier, this avoids flagging everything when the offsets agree closely.
+	minOutlier = 0.001
+)
+
+// ProbeSummary is the summary of all NTP results from a single probe.
+type ProbeSummary struct {
+	PrbId    int32
+	Stratum  int
+	RefId    string
+	Li       string
+	Poll     int
+	Results  int
+	Packets  int
+	Timeouts int
+	Offsets  []float64
+	Rtts     []float64
+
+	// Filled in by Summarize.
+	MedianOffset float64
+	MedianRtt    float64
+	Outlier      bool
+}
+
+// Summary is the summary of NTP results across a measurement.
+type Summary struct {
+	Probes   map[int32]*ProbeSummary
+	Packets  int
+	Timeouts int
+	Offsets  []float64
+
+	// Offset distribution, filled in by Summarize.
+	MinOffset, MaxOffset  float64
+	P05, P50, P95         float64
+	MAD, OutlierThreshold float64
+}
+
+// New creates an empty NTP summary.
+func New() *Summary {
+	return &Summary{Probes: map[int32]*ProbeSummary{}}
+}
+
+// Add records a single NTP result, results of other types are ignored.
+func (s *Summary) Add(m messages.MeasurementResultMessage) {
+	if m.Type != "ntp" {
+		return
+	}
+	p, ok := s.Probes[m.PrbId]
+	if !ok {
+		p = &ProbeSummary{PrbId: m.PrbId}
+		s.Probes[m.PrbId] = p
+	}
+	// The server details reported are those from the latest result.
+	p.Stratum = m.Stratum
+	p.RefId = m.RefId
+	p.Li = m.Li
+	p.Poll = m.Poll
+	p.Results++
+
+	for _, e := range m.Result.Entries {
+		p.Packets++
+		s.Packets++
+		if e.Timeout() || e.Error != "" {
+			p.Timeouts++
+			s.Timeouts++
+			continue
+		}
+		p.Offsets = append(p.Offsets, e.Offset)
+		p.Rtts = append(p.Rtts, e.Rtt)
+		s.Offsets = append(s.Offsets, e.Offset)
+	}
+}
+
+// Summarize computes the offset distribution and flags the probes whose
+// median offset is an outlier compared to the rest of the measurement.
+func (s *Summary) Summarize() {
+	if len(s.Offsets) == 0 {
+		return
+	}
+	offsets := stats.Sorted(s.Offsets)
+	s.MinOffset = offsets[0]
+	s.MaxOffset = offsets[len(offsets)-1]
+	s.P05 = stats.Percentile(offsets, 5)
+	s.P50 = stats.Percentile(offsets, 50)
+	s.P95 = stats.Percentile(offsets, 95)
+
+	// The median absolute deviation of the per-probe medians sets the bar
+	// for an outlier, it is robust to the outliers themselves.
+	var devs []float64
+	for _, p := range s.Probes {
+		if len(p.Offsets) == 0 {
+			continue
+		}
+		p.MedianOffset = stats.Percentile(stats.Sorted(p.Offsets), 50)
+		p.MedianRtt = stats.Percentile(stats.Sorted(p.Rtts), 50)
+		devs = append(devs, math.Abs(p.MedianOffset-s.P50))
+	}
+	s.MAD = stats.Percentile(stats.Sorted(devs), 50)
+	s.OutlierThreshold = math.Max(outlierFactor*s.MAD, minOutlier)
+
+	for _, p := range s.Probes {
+		if len(p.Offsets) == 0 {
+			continue
+		}
+		p.Outlier = math.Abs(p.MedianOffset-s.P50) > s.OutlierThreshold
+	}
+}
+
+// Outliers returns the probes flagged as outliers by Summarize, sorted by id.
+func (s *Summary) Outliers() []*ProbeSummary {
+	var res []*ProbeSummary
+	for _, p := range s.sortedProbes() {
+		if p.Outlier {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// String is a printable report of the summary, and each probe in it.
+// Offsets and round trip times are reported in milliseconds.
+func (s *Summary) String() string {
+	var res []string
+	res = append(res, fmt.Sprintf(
+		"NTP probes: %d packets: %d timeouts: %d", len(s.Probes), s.Packets, s.Timeouts))
+	if len(s.Offsets) > 0 {
+		res = append(res, fmt.Sprintf(
+			"Offset(ms) min: %0.3f p5: %0.3f p50: %0.3f p95: %0.3f max: %0.3f outlier beyond: %0.3f",
+			ms(s.MinOffset), ms(s.P05), ms(s.P50), ms(s.P95), ms(s.MaxOffset), ms(s.OutlierThreshold)))
+	}
+	for _, p := range s.sortedProbes() {
+		flag := ""
+		if p.Outlier {
+			flag = " OUTLIER"
+		}
+		res = append(res, fmt.Sprintf(
+			"\tProbe: %d stratum: %d ref-id: %v li: %v poll: %d packets: %d timeouts: %d offset(ms): %0.3f rtt(ms): %0.3f%s",
+			p.PrbId, p.Stratum, p.RefId, p.Li, p.Poll, p.Packets, p.Timeouts,
+			ms(p.MedianOffset), ms(p.MedianRtt), flag))
+	}
+	return strings.Join(res, "\n")
+}
+
+func (s *Summary) sortedProbes() []*ProbeSummary {
+	var res []*ProbeSummary
+	for _, p := range s.Probes {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].PrbId < res[j].PrbId })
+	return res
+}
+
+// ms converts seconds, as reported by NTP results, to milliseconds.
+func ms(s float64) float64 {
+	return s * 1000
+}
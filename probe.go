@@ -1,64 +1,129 @@
-// Package implements a single lookup and display for data about a single probe.
+// probe looks up probes by id, or finds them by their attributes, and prints
+// them as a table, JSON, CSV or GeoJSON. Filters combine: only probes matching
+// all of them are printed. Results spanning several pages are all requested.
+//
+//	go run probe.go -id 1001
+//	go run probe.go -ids 1001,1002,1003
+//	go run probe.go -country DE -asn 3320 -status Connected -format csv
+//	go run probe.go -metro FRA -radius 50 -anchor true -format geojson
+//	go run probe.go -tags system-ipv6-works -prefix 193.0.0.0/21 -format json
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"os"
+	"strconv"
 	"strings"
 
-	"github.com/morrowc/ripe-atlas/messages"
-)
-
-const (
-	probeReqURL = "https://atlas.ripe.net:443/api/v2/probes/?id__in=%d"
+	"github.com/morrowc/ripe-atlas/probes"
+	"github.com/morrowc/ripe-atlas/results"
 )
 
 var (
-	id = flag.Int("id", 0, "Probe id to lookup.")
+	id      = flag.Int("id", 0, "Probe id to lookup, the same as -ids with a single id.")
+	ids     = flag.String("ids", "", "Comma separated list of probe ids to lookup.")
+	country = flag.String("country", "", "Only probes in this country, by ISO code.")
+	asn     = flag.Int("asn", 0, "Only probes in this ASN, over IPv4 or IPv6.")
+	prefix  = flag.String("prefix", "", "Only probes in this announced IPv4 or IPv6 prefix.")
+	tags    = flag.String("tags", "", "Comma separated list of tags, by slug, probes must all have.")
+	status  = flag.String("status", "", "Only probes with this status: Connected, Disconnected, Abandoned or Never Connected.")
+	anchor  = flag.String("anchor", "", "Only anchors when true, only probes which are not when false.")
+	metro   = flag.String("metro", "", "Only probes within -radius of this metro.")
+	radius  = flag.Int("radius", 50, "Distance (km) from -metro to find probes within.")
+	format  = flag.String("format", "table", "Output format: table, json, csv or geojson.")
+	geo     = flag.String("geocoder", "airports", "How to locate the metro: airports, kelvins or static.")
+	geoKey  = flag.String("geocodingKey", "", "File with a google geocoding API key, defaults to $GEOCODING_API_KEY.")
+	locs    = flag.String("locations", "", "CSV file of metro,lat,long for the static geocoder.")
+	mDb     = flag.String("metros", "", "CSV file of metros to add to, or replace, the bundled metros.")
+	snap    = flag.String("snapshot", "", "Probe snapshot file (see probeSnapshot) to lookup in, instead of RIPE.")
 )
 
-func makeRequest(id int32) (string, error) {
-	url := fmt.Sprintf(probeReqURL, id)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
+// makeFilter builds the probe filter from the command-line.
+func makeFilter() (probes.Filter, error) {
+	f := probes.Filter{
+		Country: *country,
+		ASN:     int32(*asn),
+		Prefix:  *prefix,
+		Status:  *status,
 	}
-
-	defer resp.Body.Close()
-	c, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+	var err error
+	if *ids != "" {
+		if f.Ids, err = results.ParseIds(*ids); err != nil {
+			return f, err
+		}
 	}
-	var probes messages.ProbeQueryResults
-	err = json.Unmarshal(c, &probes)
-	if err != nil {
-		return "", err
+	if *id != 0 {
+		f.Ids = append(f.Ids, int32(*id))
 	}
-
-	var results []string
-	for _, p := range probes.Results {
-		results = append(results, fmt.Sprintf(
-			"Id: %d  AddrV4: %v AddrV6: %v Country: %v Public: %v",
-			p.Id, p.AddressV4, p.AddressV6, p.CountryCode, p.IsPublic))
+	if *tags != "" {
+		for _, t := range strings.Split(*tags, ",") {
+			f.Tags = append(f.Tags, strings.TrimSpace(t))
+		}
+	}
+	if *anchor != "" {
+		a, err := strconv.ParseBool(*anchor)
+		if err != nil {
+			return f, fmt.Errorf("bad -anchor(%v), use true or false", *anchor)
+		}
+		f.Anchor = &a
+	}
+	if *metro != "" {
+		if *radius <= 0 {
+			return f, fmt.Errorf("-radius must be positive")
+		}
+		g, err := probes.NewGeocoder(*geo, *geoKey, *locs, false)
+		if err != nil {
+			return f, fmt.Errorf("failed to setup the geocoder: %v", err)
+		}
+		if f.Lat, f.Long, err = g.Locate(*metro); err != nil {
+			return f, fmt.Errorf("failed to locate %v: %v", *metro, err)
+		}
+		f.Radius = *radius
 	}
-	return strings.Join(results, "\n"), nil
+	return f, nil
 }
 
 func main() {
 	flag.Parse()
 
-	if *id == 0 {
-		fmt.Printf("Provide a probe ID to lookup.")
+	if *id == 0 && *ids == "" && *country == "" && *asn == 0 && *prefix == "" && *tags == "" &&
+		*status == "" && *anchor == "" && *metro == "" {
+		fmt.Printf("Provide probe -ids, or filters to find probes by.\n")
 		return
 	}
+	known := false
+	for _, f := range probes.Formats {
+		known = known || f == *format
+	}
+	if !known {
+		fmt.Printf("Unknown -format(%v), use one of: %v\n", *format, strings.Join(probes.Formats, ", "))
+		return
+	}
+	if *snap != "" {
+		if err := probes.UseSnapshot(*snap); err != nil {
+			fmt.Printf("Failed to load the probe snapshot: %v\n", err)
+			return
+		}
+	}
+	if *mDb != "" {
+		if err := probes.Metros.Load(*mDb); err != nil {
+			fmt.Printf("Failed to load the metros: %v\n", err)
+			return
+		}
+	}
 
-	res, err := makeRequest(int32(*id))
+	f, err := makeFilter()
 	if err != nil {
-		fmt.Printf("Failed to lookup probe id(%v): %v\n", *id, err)
+		fmt.Printf("%v\n", err)
 		return
 	}
-	fmt.Printf("Probe matches:\n%v\n", res)
+	ps, err := probes.Inventory.Find(f)
+	if err != nil {
+		fmt.Printf("Failed to find probes: %v\n", err)
+		return
+	}
+	if err := probes.Write(os.Stdout, ps, *format); err != nil {
+		fmt.Printf("Failed to write the probes: %v\n", err)
+	}
 }
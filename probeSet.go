@@ -0,0 +1,109 @@
+// probeSet shows, lists or refreshes the probe sets saved by makeMeasurement
+// -probe-set. Refreshing replaces the members which have disconnected, or no
+// longer match the set's criteria, and keeps the rest.
+//
+//	go run probeSet.go
+//	go run probeSet.go -name fra-dual
+//	go run probeSet.go -name fra-dual -refresh
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/probes"
+)
+
+var (
+	name    = flag.String("name", "", "Probe set to show or refresh, all sets are listed when empty.")
+	dir     = flag.String("dir", probes.SetsDir(), "Directory probe sets are saved in.")
+	refresh = flag.Bool("refresh", false, "Replace the members which no longer match the set's criteria.")
+	geo     = flag.String("geocoder", "airports", "How to locate the metro: airports, kelvins or static.")
+	geoKey  = flag.String("geocodingKey", "", "File with a google geocoding API key, defaults to $GEOCODING_API_KEY.")
+	locs    = flag.String("locations", "", "CSV file of metro,lat,long for the static geocoder.")
+	mDb     = flag.String("metros", "", "CSV file of metros to add to, or replace, the bundled metros.")
+	snap    = flag.String("snapshot", "", "Probe snapshot file (see probeSnapshot) to refresh from, instead of RIPE.")
+)
+
+func printSet(s *probes.ProbeSet) {
+	c := s.Criteria
+	fmt.Printf("Probe set: %v metro: %v radius: %d found within: %d count: %d af: %v select: %v\n",
+		s.Name, c.Metro, c.Radius, s.Radius, c.Count, c.AF, c.Select)
+	fmt.Printf("\tcreated: %v updated: %v\n",
+		s.Created.Format(time.RFC3339), s.Updated.Format(time.RFC3339))
+	fmt.Printf("\tprobes(%d): %v\n", len(s.Probes), s.Probes)
+}
+
+func main() {
+	flag.Parse()
+
+	if *name == "" {
+		files, err := ioutil.ReadDir(*dir)
+		if err != nil {
+			fmt.Printf("failed to list the probe sets: %v\n", err)
+			return
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			s, err := probes.LoadSet(*dir, strings.TrimSuffix(f.Name(), ".json"))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			printSet(s)
+		}
+		return
+	}
+
+	s, err := probes.LoadSet(*dir, *name)
+	if err != nil {
+		fmt.Printf("failed to load the probe set: %v\n", err)
+		return
+	}
+	if !*refresh {
+		printSet(s)
+		return
+	}
+
+	if *snap != "" {
+		if err := probes.UseSnapshot(*snap); err != nil {
+			fmt.Printf("failed to load the probe snapshot: %v\n", err)
+			return
+		}
+	}
+	if *mDb != "" {
+		if err := probes.Metros.Load(*mDb); err != nil {
+			fmt.Printf("failed to load the metros: %v\n", err)
+			return
+		}
+	}
+	g, err := probes.NewGeocoder(*geo, *geoKey, *locs, false)
+	if err != nil {
+		fmt.Printf("failed to setup the geocoder: %v\n", err)
+		return
+	}
+	removed, added, err := s.Refresh(g)
+	if err != nil {
+		fmt.Printf("failed to refresh the probe set: %v\n", err)
+		return
+	}
+	if len(removed) == 0 {
+		fmt.Printf("All members still match, nothing to refresh.\n")
+		printSet(s)
+		return
+	}
+	if err := s.Save(*dir); err != nil {
+		fmt.Printf("failed to save the probe set: %v\n", err)
+		return
+	}
+	fmt.Printf("Removed: %v added: %v\n", removed, added)
+	if len(added) < len(removed) {
+		fmt.Printf("Only %d of %d replacements found.\n", len(added), len(removed))
+	}
+	printSet(s)
+}
@@ -0,0 +1,35 @@
+// probeSnapshot downloads the full RIPE Atlas probe list into a local
+// snapshot file. makeMeasurement, probe and probeSet answer their probe
+// queries from the snapshot when given -snapshot, so probe selection is
+// fast, and reproducible for the snapshot's date.
+//
+//	go run probeSnapshot.go
+//	go run probeSnapshot.go -out probes-2019-01-06.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/probes"
+)
+
+var (
+	out = flag.String("out", probes.SnapshotPath(), "File to save the snapshot in.")
+)
+
+func main() {
+	flag.Parse()
+
+	s, err := probes.TakeSnapshot()
+	if err != nil {
+		fmt.Printf("failed to download the probes: %v\n", err)
+		return
+	}
+	if err := s.Save(*out); err != nil {
+		fmt.Printf("failed to save the snapshot: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved %d probes, taken: %v, to: %v\n", len(s.Probes), s.Taken.Format(time.RFC3339), *out)
+}
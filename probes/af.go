@@ -0,0 +1,74 @@
+package probes
+
+import (
+	"fmt"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Address family modes, selecting probes by the address families they can
+// measure over.
+const (
+	// V4Capable probes work over IPv4, and may also work over IPv6.
+	V4Capable = "v4-capable"
+	// V6Capable probes work over IPv6, and may also work over IPv4.
+	V6Capable = "v6-capable"
+	// DualStack probes work over both IPv4 and IPv6.
+	DualStack = "dual-stack"
+	// V4Only probes work over IPv4, and not over IPv6.
+	V4Only = "v4-only"
+	// V6Only probes work over IPv6, and not over IPv4.
+	V6Only = "v6-only"
+)
+
+// Works reports whether a probe can measure over address family af (4 or 6).
+// The system tags RIPE sets from its own measurements decide, when present,
+// otherwise whether the probe has an address in the family.
+func Works(p messages.ProbeMessage, af int) bool {
+	switch {
+	case HasTag(p, fmt.Sprintf("system-ipv%d-doesnt-work", af)):
+		return false
+	case HasTag(p, fmt.Sprintf("system-ipv%d-works", af)):
+		return true
+	case af == 6:
+		return p.AddressV6 != ""
+	}
+	return p.AddressV4 != ""
+}
+
+// MatchAF reports whether a probe matches the address family mode.
+func MatchAF(p messages.ProbeMessage, mode string) (bool, error) {
+	v4, v6 := Works(p, 4), Works(p, 6)
+	switch mode {
+	case V4Capable:
+		return v4, nil
+	case V6Capable:
+		return v6, nil
+	case DualStack:
+		return v4 && v6, nil
+	case V4Only:
+		return v4 && !v6, nil
+	case V6Only:
+		return v6 && !v4, nil
+	}
+	return false, fmt.Errorf("unknown address family mode(%v), use %v, %v, %v, %v or %v",
+		mode, V4Capable, V6Capable, DualStack, V4Only, V6Only)
+}
+
+// ModeAF returns the address family a mode measures over, 6 for the IPv6
+// modes and 4 otherwise.
+func ModeAF(mode string) int {
+	if mode == V6Capable || mode == V6Only {
+		return 6
+	}
+	return 4
+}
+
+// ModeAFs returns the address families a mode needs the probe to work over:
+// both for dual-stack, otherwise the one of ModeAF.
+func ModeAFs(mode string) []int {
+	if mode == DualStack {
+		return []int{4, 6}
+	}
+	return []int{ModeAF(mode)}
+}
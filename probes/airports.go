@@ -0,0 +1,270 @@
+package probes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Use the openflights data from github, cache locally for repeat usage.
+	openflightsURL = "https://raw.githubusercontent.com/jpatokal/openflights/master/data/airports.dat"
+	airportsFile   = "airports.dat"
+	// airportsMaxAge is how long the cached data is used before a refresh.
+	airportsMaxAge = 30 * 24 * time.Hour
+	// minAirportRows is the fewest rows downloaded data may have, fewer is
+	// likely a truncated download or an error page. The full data has ~7000.
+	minAirportRows = 5000
+	// airportFields is the number of fields in each row of the data.
+	airportFields = 14
+)
+
+var (
+	// Airports is the airports dataset used to locate metros.
+	Airports = NewAirportData()
+
+	// airportsSnapshot is a subset of the openflights data, the airports of
+	// the metros in measurements/metros, used when the data can not be
+	// downloaded and there is no cache.
+	//go:embed airports_snapshot.dat
+	airportsSnapshot []byte
+)
+
+// 2,"Madang Airport","Madang","Papua New Guinea",
+//   "MAG","AYMD",-5.20707988739,145.789001465,20,10,
+//   "U","Pacific/Port_Moresby","airport","OurAirports"
+
+type airport struct {
+	id                               int32
+	name, city, country, code, kcode string
+	lat, long                        float64
+	altitude, tz                     int32
+	dst, tzDatabase, recType, source string
+}
+
+type airports []airport
+
+// AirportData manages the openflights airports data: downloaded from URL,
+// cached in Dir and refreshed once older than MaxAge. Downloads are checked
+// for at least MinRows rows before replacing the cache, and the cache is
+// checked against the checksum written with it.
+type AirportData struct {
+	URL     string
+	Dir     string
+	MaxAge  time.Duration
+	MinRows int
+	// Source is where the data loaded came from: cache, download or snapshot.
+	Source string
+	// Skipped is the number of rows of the data loaded which did not parse,
+	// and were left out.
+	Skipped int
+
+	aps airports
+}
+
+// NewAirportData creates an AirportData cached in the user's cache
+// directory ($XDG_CACHE_HOME/ripe-atlas), or the temporary directory when
+// there is none.
+func NewAirportData() *AirportData {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return &AirportData{
+		URL:     openflightsURL,
+		Dir:     filepath.Join(dir, "ripe-atlas"),
+		MaxAge:  airportsMaxAge,
+		MinRows: minAirportRows,
+	}
+}
+
+// Path returns the path of the cached data.
+func (d *AirportData) Path() string {
+	return filepath.Join(d.Dir, airportsFile)
+}
+
+// load returns the airports, from the cache when it is fresh, otherwise
+// downloaded. A stale cache is used when the download fails, and the
+// embedded snapshot when there is no cache at all.
+func (d *AirportData) load() (airports, error) {
+	if d.aps != nil {
+		return d.aps, nil
+	}
+	aps, fresh, cacheErr := d.readCache()
+	if cacheErr == nil && fresh {
+		d.aps, d.Source = aps, "cache"
+		return aps, nil
+	}
+	if err := d.Refresh(); err == nil {
+		if aps, _, cacheErr = d.readCache(); cacheErr == nil {
+			d.aps, d.Source = aps, "download"
+			return aps, nil
+		}
+	}
+	if aps != nil {
+		d.aps, d.Source = aps, "cache"
+		return aps, nil
+	}
+	aps, skipped, err := parseAirports(bytes.NewReader(airportsSnapshot))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the airports snapshot: %v", err)
+	}
+	d.aps, d.Source, d.Skipped = aps, "snapshot", skipped
+	return aps, nil
+}
+
+// readCache parses the cached data, after checking it against its checksum,
+// and reports whether it is younger than MaxAge. Skipped is set to the rows
+// which did not parse.
+func (d *AirportData) readCache() (airports, bool, error) {
+	fi, err := os.Stat(d.Path())
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := ioutil.ReadFile(d.Path())
+	if err != nil {
+		return nil, false, err
+	}
+	sum, err := ioutil.ReadFile(d.Path() + ".sha256")
+	if err != nil {
+		return nil, false, fmt.Errorf("no checksum for the cached airports: %v", err)
+	}
+	if strings.TrimSpace(string(sum)) != checksum(data) {
+		return nil, false, fmt.Errorf("the cached airports(%v) do not match their checksum", d.Path())
+	}
+	aps, skipped, err := parseAirports(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	d.Skipped = skipped
+	return aps, time.Since(fi.ModTime()) < d.MaxAge, nil
+}
+
+// Refresh downloads the data and, when enough rows parse, replaces the
+// cache and its checksum. Rows which do not parse are skipped.
+func (d *AirportData) Refresh() error {
+	resp, err := http.Get(d.URL)
+	if err != nil {
+		return fmt.Errorf("failed to download the airports: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download the airports: %v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read the airports: %v", err)
+	}
+
+	aps, skipped, err := parseAirports(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if len(aps) < d.MinRows {
+		return fmt.Errorf("the airports downloaded have %d good rows (%d skipped), want at least %d",
+			len(aps), skipped, d.MinRows)
+	}
+
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	if err := writeAtomic(d.Path()+".sha256", []byte(checksum(data)+"\n")); err != nil {
+		return err
+	}
+	return writeAtomic(d.Path(), data)
+}
+
+// checksum returns the hex sha256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeAtomic writes data to a temporary file beside path, then renames it
+// into place, so readers never see a partial file.
+func writeAtomic(path string, data []byte) error {
+	fd, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// parseAirports parses the openflights data into a slice of airport structs.
+// Rows which do not parse, with the wrong number of fields or without a
+// location, are skipped and counted.
+func parseAirports(r io.Reader) (airports, int, error) {
+	csvReader := csv.NewReader(r)
+	// There are instances of \"foo\" inside a field
+	// in this data, lazyquotes avoids erroring.
+	csvReader.LazyQuotes = true
+	csvReader.FieldsPerRecord = -1
+	var aps airports
+	skipped := 0
+	for {
+		rec, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse the airports: %v", err)
+		}
+		if len(rec) != airportFields {
+			skipped++
+			continue
+		}
+		var a airport
+		// Unknown values are \N, and parse as 0.
+		tmp, _ := strconv.ParseInt(rec[0], 10, 32)
+		a.id = int32(tmp)
+		a.name = rec[1]
+		a.city = rec[2]
+		a.country = rec[3]
+		a.code = rec[4]
+		a.kcode = rec[5]
+		var errLat, errLong error
+		a.lat, errLat = strconv.ParseFloat(rec[6], 64)
+		a.long, errLong = strconv.ParseFloat(rec[7], 64)
+		if errLat != nil || errLong != nil {
+			skipped++
+			continue
+		}
+		tmp, _ = strconv.ParseInt(rec[8], 10, 32)
+		a.altitude = int32(tmp)
+		tmp, _ = strconv.ParseInt(rec[9], 10, 32)
+		a.tz = int32(tmp)
+		a.dst = rec[10]
+		a.tzDatabase = rec[11]
+		a.recType = rec[12]
+		a.source = rec[13]
+		aps = append(aps, a)
+	}
+	return aps, skipped, nil
+}
@@ -0,0 +1,150 @@
+package probes
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Formats are the names of the formats Write supports.
+var Formats = []string{"table", "json", "csv", "geojson"}
+
+// columns are the probe fields of the table and CSV formats.
+var columns = []string{
+	"id", "status", "country", "asn_v4", "asn_v6", "address_v4", "address_v6",
+	"anchor", "public", "lat", "long", "description",
+}
+
+// fields returns the probe's values of each of the columns.
+func fields(p messages.ProbeMessage) []string {
+	var lat, long string
+	if la, lo, ok := Location(p); ok {
+		lat = strconv.FormatFloat(la, 'f', 4, 64)
+		long = strconv.FormatFloat(lo, 'f', 4, 64)
+	}
+	return []string{
+		strconv.Itoa(int(p.Id)), p.Status.Name, p.CountryCode,
+		strconv.Itoa(int(p.ASNv4)), strconv.Itoa(int(p.ASNv6)),
+		p.AddressV4, p.AddressV6,
+		strconv.FormatBool(p.IsAnchor), strconv.FormatBool(p.IsPublic),
+		lat, long, p.Description,
+	}
+}
+
+// Write writes the probes to w in the named format: an aligned table, a JSON
+// list, CSV with a header line, or a GeoJSON FeatureCollection.
+func Write(w io.Writer, ps []messages.ProbeMessage, format string) error {
+	switch format {
+	case "table":
+		return writeTable(w, ps)
+	case "json":
+		return writeJSON(w, ps)
+	case "csv":
+		return writeCSV(w, ps)
+	case "geojson":
+		return writeGeoJSON(w, ps)
+	}
+	return fmt.Errorf("unknown format(%v), use one of: %v", format, strings.Join(Formats, ", "))
+}
+
+func writeTable(w io.Writer, ps []messages.ProbeMessage) error {
+	lines := [][]string{columns}
+	for _, p := range ps {
+		lines = append(lines, fields(p))
+	}
+	widths := make([]int, len(columns))
+	for _, l := range lines {
+		for i, f := range l {
+			if len(f) > widths[i] {
+				widths[i] = len(f)
+			}
+		}
+	}
+	for _, l := range lines {
+		var fs []string
+		for i, f := range l {
+			fs = append(fs, fmt.Sprintf("%-*s", widths[i], f))
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(fs, "  "), " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, ps []messages.ProbeMessage) error {
+	if ps == nil {
+		ps = []messages.ProbeMessage{}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(ps)
+}
+
+func writeCSV(w io.Writer, ps []messages.ProbeMessage) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, p := range ps {
+		if err := cw.Write(fields(p)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	Geometry   messages.GeometryMsg   `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// properties returns the probe's values of each of the columns, as numbers,
+// booleans and strings.
+func properties(p messages.ProbeMessage) map[string]interface{} {
+	// Locations are float32, shortened to the digits they hold.
+	short := func(f float64) float64 {
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+		return f
+	}
+	lat, long, _ := Location(p)
+	values := []interface{}{
+		p.Id, p.Status.Name, p.CountryCode, p.ASNv4, p.ASNv6,
+		p.AddressV4, p.AddressV6, p.IsAnchor, p.IsPublic,
+		short(lat), short(long), p.Description,
+	}
+	res := map[string]interface{}{}
+	for i, v := range values {
+		res[columns[i]] = v
+	}
+	return res
+}
+
+// writeGeoJSON writes a Point feature for each located probe, with the
+// table columns as its properties. Probes without a location are left out.
+func writeGeoJSON(w io.Writer, ps []messages.ProbeMessage) error {
+	fc := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	for _, p := range ps {
+		if _, _, ok := Location(p); !ok {
+			continue
+		}
+		fc.Features = append(fc.Features, feature{
+			Type:       "Feature",
+			Geometry:   messages.GeometryMsg{Type: "Point", Coordinates: p.Geometry.Coordinates[:2]},
+			Properties: properties(p),
+		})
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(fc)
+}
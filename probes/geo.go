@@ -0,0 +1,32 @@
+package probes
+
+import (
+	"math"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+const (
+	// earthRadius is the mean radius of the earth, in kilometers.
+	earthRadius = 6371.0
+)
+
+// Distance returns the great circle distance, in kilometers, between two
+// points given in degrees, using the haversine formula.
+func Distance(lat1, long1, lat2, long2 float64) float64 {
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLong := rad(long2 - long1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Location returns a probe's latitude and longitude, from its GeoJSON
+// geometry which holds [longitude, latitude].
+func Location(p messages.ProbeMessage) (float64, float64, bool) {
+	if len(p.Geometry.Coordinates) < 2 {
+		return 0, 0, false
+	}
+	return float64(p.Geometry.Coordinates[1]), float64(p.Geometry.Coordinates[0]), true
+}
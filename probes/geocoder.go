@@ -0,0 +1,155 @@
+package probes
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kelvins/geocoder"
+)
+
+const (
+	// geocodingKeyEnv is the environment variable holding a key for google's
+	// geocoding API, when no key file is given.
+	geocodingKeyEnv = "GEOCODING_API_KEY"
+)
+
+// Geocoder locates a metro (FRA), returning its latitude and longitude.
+type Geocoder interface {
+	Locate(metro string) (float64, float64, error)
+}
+
+// AirportGeocoder locates a metro from the Metros database, or by its
+// airports' coordinates from the openflights airports data. Metros without
+// coordinates are located by the Fallback geocoder, when set.
+type AirportGeocoder struct {
+	Fallback Geocoder
+}
+
+// Locate returns the location of the metro.
+func (g AirportGeocoder) Locate(metro string) (float64, float64, error) {
+	m, err := Metros.Lookup(metro)
+	if err != nil {
+		return 0, 0, err
+	}
+	if m.Lat != 0 || m.Long != 0 {
+		return m.Lat, m.Long, nil
+	}
+	if g.Fallback == nil {
+		return 0, 0, fmt.Errorf("metro(%v) has no location", metro)
+	}
+	return g.Fallback.Locate(metro)
+}
+
+// KelvinsGeocoder locates a metro by geocoding its city and country, with
+// google's geocoding API.
+type KelvinsGeocoder struct {
+	Key string
+}
+
+// NewKelvinsGeocoder creates a KelvinsGeocoder with the API key read from
+// keyFile, or from the GEOCODING_API_KEY environment variable.
+func NewKelvinsGeocoder(keyFile string) (*KelvinsGeocoder, error) {
+	if keyFile == "" {
+		k := os.Getenv(geocodingKeyEnv)
+		if k == "" {
+			return nil, fmt.Errorf("no geocoding key file, and %v is not set", geocodingKeyEnv)
+		}
+		return &KelvinsGeocoder{Key: k}, nil
+	}
+	k, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the geocoding key: %v", err)
+	}
+	return &KelvinsGeocoder{Key: strings.TrimSpace(string(k))}, nil
+}
+
+// Locate geocodes the city/country of the metro.
+func (g KelvinsGeocoder) Locate(metro string) (float64, float64, error) {
+	m, err := Metros.Lookup(metro)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Setup the geocoder API request basics, address and run the conversion.
+	geocoder.ApiKey = g.Key
+	address := geocoder.Address{
+		City:    m.City,
+		Country: m.Country,
+	}
+	location, err := geocoder.Geocoding(address)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to convert address -> location: %v", err)
+	}
+	return location.Latitude, location.Longitude, nil
+}
+
+// StaticGeocoder locates metros from a fixed table, keyed by upper-case
+// metro code, of [latitude, longitude].
+type StaticGeocoder map[string][2]float64
+
+// LoadStaticGeocoder reads a StaticGeocoder from a CSV file of:
+// metro,lat,long
+func LoadStaticGeocoder(path string) (StaticGeocoder, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	r := csv.NewReader(fd)
+	r.Comment = '#'
+	r.FieldsPerRecord = 3
+	res := StaticGeocoder{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the metro locations(%v): %v", path, err)
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		long, errLong := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+		if errLat != nil || errLong != nil {
+			return nil, fmt.Errorf("metro locations(%v): bad location for %v", path, rec[0])
+		}
+		res[strings.ToUpper(strings.TrimSpace(rec[0]))] = [2]float64{lat, long}
+	}
+	return res, nil
+}
+
+// Locate returns the metro's location from the table.
+func (g StaticGeocoder) Locate(metro string) (float64, float64, error) {
+	l, ok := g[strings.ToUpper(metro)]
+	if !ok {
+		return 0, 0, fmt.Errorf("no location for metro(%v)", metro)
+	}
+	return l[0], l[1], nil
+}
+
+// NewGeocoder creates the named geocoder: airports, kelvins or static. The
+// kelvins key is read from keyFile, the static table from locations. With
+// fallback set, the airports geocoder falls back to kelvins.
+func NewGeocoder(name, keyFile, locations string, fallback bool) (Geocoder, error) {
+	switch name {
+	case "airports":
+		g := AirportGeocoder{}
+		if fallback {
+			k, err := NewKelvinsGeocoder(keyFile)
+			if err != nil {
+				return nil, err
+			}
+			g.Fallback = k
+		}
+		return g, nil
+	case "kelvins":
+		return NewKelvinsGeocoder(keyFile)
+	case "static":
+		return LoadStaticGeocoder(locations)
+	}
+	return nil, fmt.Errorf("unknown geocoder(%v), use airports, kelvins or static", name)
+}
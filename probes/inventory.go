@@ -0,0 +1,291 @@
+package probes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+const (
+	probesUrl = "https://atlas.ripe.net/api/v2/probes/?"
+	// pageSize is the number of probes requested per page, RIPE's maximum.
+	pageSize = 500
+	// lookupBatch is the number of probe ids requested at once, keeping
+	// the request URL to a reasonable length.
+	lookupBatch = 100
+)
+
+var (
+	// Inventory is the source of the probe details used by LocateProbes and
+	// Lookup, RIPE by default or a local Snapshot.
+	Inventory Source = AtlasAPI{}
+
+	// statusIds are RIPE's ids of the probe status names.
+	statusIds = map[string]int{
+		"Never Connected": 0,
+		"Connected":       1,
+		"Disconnected":    2,
+		"Abandoned":       3,
+	}
+)
+
+// Filter selects probes by their attributes, fields left unset match all
+// probes.
+type Filter struct {
+	Ids     []int32
+	Country string
+	// ASN matches either the IPv4 or IPv6 ASN.
+	ASN int32
+	// Prefix matches the announced IPv4 or IPv6 prefix exactly.
+	Prefix string
+	// Tags are tags, by slug, the probe must all have.
+	Tags []string
+	// Status is a status name: Connected, Disconnected, Abandoned or
+	// Never Connected.
+	Status string
+	Anchor *bool
+	Public bool
+	// Radius, when set, matches probes within Radius km of Lat, Long.
+	Lat, Long float64
+	Radius    int
+}
+
+// Match reports whether a probe matches the filter.
+func (f Filter) Match(p messages.ProbeMessage) bool {
+	if len(f.Ids) > 0 {
+		found := false
+		for _, id := range f.Ids {
+			found = found || id == p.Id
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case f.Country != "" && !strings.EqualFold(f.Country, p.CountryCode),
+		f.ASN != 0 && f.ASN != p.ASNv4 && f.ASN != p.ASNv6,
+		f.Prefix != "" && f.Prefix != p.PrefixV4 && f.Prefix != p.PrefixV6,
+		f.Status != "" && f.Status != p.Status.Name,
+		f.Anchor != nil && *f.Anchor != p.IsAnchor,
+		f.Public && !p.IsPublic:
+		return false
+	}
+	for _, t := range f.Tags {
+		if !HasTag(p, t) {
+			return false
+		}
+	}
+	if f.Radius > 0 {
+		lat, long, ok := Location(p)
+		if !ok || Distance(f.Lat, f.Long, lat, long) > float64(f.Radius) {
+			return false
+		}
+	}
+	return true
+}
+
+// check validates the filter's values.
+func (f Filter) check() error {
+	if _, ok := statusIds[f.Status]; f.Status != "" && !ok {
+		return fmt.Errorf("unknown probe status(%v), use Connected, Disconnected, Abandoned or Never Connected", f.Status)
+	}
+	if f.Prefix != "" {
+		if _, _, err := net.ParseCIDR(f.Prefix); err != nil {
+			return fmt.Errorf("bad prefix: %v", err)
+		}
+	}
+	return nil
+}
+
+// query returns the RIPE API query parameters of the filter, without ids.
+func (f Filter) query() url.Values {
+	q := url.Values{}
+	q.Set("page_size", strconv.Itoa(pageSize))
+	q.Set("sort", "id")
+	if f.Country != "" {
+		q.Set("country_code", strings.ToUpper(f.Country))
+	}
+	if f.ASN != 0 {
+		q.Set("asn", strconv.Itoa(int(f.ASN)))
+	}
+	if f.Prefix != "" {
+		if strings.Contains(f.Prefix, ":") {
+			q.Set("prefix_v6", f.Prefix)
+		} else {
+			q.Set("prefix_v4", f.Prefix)
+		}
+	}
+	if len(f.Tags) > 0 {
+		q.Set("tags", strings.Join(f.Tags, ","))
+	}
+	if f.Status != "" {
+		q.Set("status", strconv.Itoa(statusIds[f.Status]))
+	}
+	if f.Anchor != nil {
+		q.Set("is_anchor", strconv.FormatBool(*f.Anchor))
+	}
+	if f.Public {
+		q.Set("is_public", "true")
+	}
+	if f.Radius > 0 {
+		q.Set("radius", fmt.Sprintf("%f,%f:%d", f.Lat, f.Long, f.Radius))
+	}
+	return q
+}
+
+// Source answers probe queries, the probes matching a filter sorted by id.
+// Now is the time the probes' details are from, which their uptime and
+// connection time are measured to.
+type Source interface {
+	Find(f Filter) ([]messages.ProbeMessage, error)
+	Now() time.Time
+}
+
+// AtlasAPI is a Source querying RIPE, following the pages of results.
+type AtlasAPI struct{}
+
+// Find requests the probes matching the filter from RIPE. Ids are requested
+// in batches, keeping the request URL to a reasonable length.
+func (AtlasAPI) Find(f Filter) ([]messages.ProbeMessage, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	q := f.query()
+	if len(f.Ids) == 0 {
+		return findPages(probesUrl + q.Encode())
+	}
+
+	var res []messages.ProbeMessage
+	ids := f.Ids
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > lookupBatch {
+			n = lookupBatch
+		}
+		var batch []string
+		for _, id := range ids[:n] {
+			batch = append(batch, strconv.Itoa(int(id)))
+		}
+		ids = ids[n:]
+
+		q.Set("id__in", strings.Join(batch, ","))
+		ps, err := findPages(probesUrl + q.Encode())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ps...)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return res, nil
+}
+
+// Now is the current time, RIPE's details are live.
+func (AtlasAPI) Now() time.Time {
+	return time.Now()
+}
+
+// findPages requests a list of probes, following the pages of results.
+func findPages(u string) ([]messages.ProbeMessage, error) {
+	var res []messages.ProbeMessage
+	for u != "" {
+		pq, err := getProbePage(u)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pq.Results...)
+		u = pq.Next
+	}
+	return res, nil
+}
+
+// Snapshot is the full probe list at a point in time, saved locally so
+// queries are fast, and reproducible for the snapshot's date.
+type Snapshot struct {
+	Taken  time.Time               `json:"taken"`
+	Probes []messages.ProbeMessage `json:"probes"`
+}
+
+// SnapshotPath returns the default path of the snapshot,
+// $XDG_CACHE_HOME/ripe-atlas/probes.json.
+func SnapshotPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ripe-atlas", "probes.json")
+}
+
+// TakeSnapshot requests the full probe list from RIPE.
+func TakeSnapshot() (*Snapshot, error) {
+	ps, err := AtlasAPI{}.Find(Filter{})
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Taken: time.Now().UTC(), Probes: ps}, nil
+}
+
+// LoadSnapshot reads a snapshot from path.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse the probe snapshot(%v): %v", path, err)
+	}
+	sort.Slice(s.Probes, func(i, j int) bool { return s.Probes[i].Id < s.Probes[j].Id })
+	return &s, nil
+}
+
+// Save writes the snapshot to path, replacing any there.
+func (s *Snapshot) Save(path string) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeAtomic(path, body)
+}
+
+// UseSnapshot makes the snapshot at path the Inventory.
+func UseSnapshot(path string) error {
+	s, err := LoadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	Inventory = s
+	return nil
+}
+
+// Now is the time the snapshot was taken, so selections from it are the
+// same whenever they are made.
+func (s *Snapshot) Now() time.Time {
+	return s.Taken
+}
+
+// Find returns the probes of the snapshot matching the filter.
+func (s *Snapshot) Find(f Filter) ([]messages.ProbeMessage, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	var res []messages.ProbeMessage
+	for _, p := range s.Probes {
+		if f.Match(p) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
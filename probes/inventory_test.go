@@ -0,0 +1,162 @@
+package probes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// taken is when the test snapshot was taken.
+var taken = time.Date(2019, 1, 6, 0, 0, 0, 0, time.UTC)
+
+func tags(slugs ...string) []messages.TagSet {
+	var res []messages.TagSet
+	for _, s := range slugs {
+		res = append(res, messages.TagSet{Slug: s})
+	}
+	return res
+}
+
+func at(lat, long float32) messages.GeometryMsg {
+	return messages.GeometryMsg{Type: "Point", Coordinates: []float32{long, lat}}
+}
+
+// testSnapshot holds probes around FRA and AMS, and one without a location.
+func testSnapshot() *Snapshot {
+	day := int32(24 * 60 * 60)
+	start := int32(taken.Unix()) - 100*day
+	connected := messages.ProbeStatus{Id: 1, Name: "Connected"}
+	return &Snapshot{Taken: taken, Probes: []messages.ProbeMessage{
+		{
+			Id: 1, CountryCode: "DE", ASNv4: 3320, PrefixV4: "193.0.0.0/21",
+			AddressV4: "193.0.0.1", Status: connected, IsPublic: true,
+			FirstConnected: start, TotalUptime: 100 * day, StatusSince: start,
+			Tags:     tags("system-ipv4-works", "system-ipv4-stable-1d"),
+			Geometry: at(50.03, 8.57),
+		},
+		{
+			Id: 2, CountryCode: "DE", ASNv6: 3320, PrefixV6: "2001:db8::/32",
+			AddressV4: "193.0.1.1", AddressV6: "2001:db8::1", Status: connected,
+			IsPublic: true, IsAnchor: true,
+			FirstConnected: start, TotalUptime: 50 * day, StatusSince: int32(taken.Unix()) - day,
+			Tags:     tags("system-ipv4-works", "system-ipv4-stable-1d", "system-ipv6-works", "system-ipv6-stable-1d"),
+			Geometry: at(50.13, 8.57),
+		},
+		{
+			Id: 3, CountryCode: "NL", ASNv4: 1103, PrefixV4: "145.0.0.0/16",
+			AddressV4: "145.0.0.1", Status: messages.ProbeStatus{Id: 2, Name: "Disconnected"},
+			IsPublic: true, Tags: tags("system-ipv4-stable-1d"),
+			Geometry: at(52.31, 4.76),
+		},
+		{
+			Id: 4, CountryCode: "de", ASNv4: 3320, PrefixV4: "193.0.0.0/16",
+			AddressV4: "193.0.2.1", Status: connected,
+			FirstConnected: start, TotalUptime: 100 * day, StatusSince: start,
+			Tags:     tags("system-ipv4-stable-1d"),
+			Geometry: at(50.03, 8.60),
+		},
+		{
+			Id: 5, CountryCode: "US", ASNv4: 7018, AddressV4: "12.0.0.1",
+			Status: connected, IsPublic: true,
+		},
+	}}
+}
+
+func TestSnapshotFind(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		filter Filter
+		// query is the API query parameter the filter sets, and value its
+		// value, so the snapshot and RIPE agree on the field.
+		query, value string
+		want         []int32
+	}{
+		{name: "all", want: []int32{1, 2, 3, 4, 5}},
+		{name: "ids", filter: Filter{Ids: []int32{3, 1, 9}}, want: []int32{1, 3}},
+		{name: "country", filter: Filter{Country: "de"}, query: "country_code", value: "DE", want: []int32{1, 2, 4}},
+		{name: "asn v4 or v6", filter: Filter{ASN: 3320}, query: "asn", value: "3320", want: []int32{1, 2, 4}},
+		// RIPE matches the announced prefix exactly, not the prefixes
+		// which contain it: 193.0.0.0/16 does not match 193.0.0.0/21.
+		{name: "prefix v4", filter: Filter{Prefix: "193.0.0.0/21"}, query: "prefix_v4", value: "193.0.0.0/21", want: []int32{1}},
+		{name: "prefix v4 covering", filter: Filter{Prefix: "193.0.0.0/16"}, query: "prefix_v4", value: "193.0.0.0/16", want: []int32{4}},
+		{name: "prefix v6", filter: Filter{Prefix: "2001:db8::/32"}, query: "prefix_v6", value: "2001:db8::/32", want: []int32{2}},
+		{name: "tags", filter: Filter{Tags: []string{"system-ipv4-works", "system-ipv6-works"}}, query: "tags", value: "system-ipv4-works,system-ipv6-works", want: []int32{2}},
+		{name: "status", filter: Filter{Status: "Disconnected"}, query: "status", value: "2", want: []int32{3}},
+		{name: "anchor", filter: Filter{Anchor: &yes}, query: "is_anchor", value: "true", want: []int32{2}},
+		{name: "not anchor", filter: Filter{Anchor: &no}, query: "is_anchor", value: "false", want: []int32{1, 3, 4, 5}},
+		{name: "public", filter: Filter{Public: true}, query: "is_public", value: "true", want: []int32{1, 2, 3, 5}},
+		{name: "radius", filter: Filter{Lat: 50.03, Long: 8.57, Radius: 20}, query: "radius", value: "50.030000,8.570000:20", want: []int32{1, 2, 4}},
+		{name: "combined", filter: Filter{Country: "DE", Status: "Connected", Public: true, Lat: 50.03, Long: 8.57, Radius: 5}, want: []int32{1}},
+	}
+	s := testSnapshot()
+	for _, tc := range tests {
+		ps, err := s.Find(tc.filter)
+		if err != nil {
+			t.Errorf("%v: Find() failed: %v", tc.name, err)
+			continue
+		}
+		var got []int32
+		for _, p := range ps {
+			got = append(got, p.Id)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: Find() = %v, want %v", tc.name, got, tc.want)
+		}
+		if tc.query == "" {
+			continue
+		}
+		if got := tc.filter.query().Get(tc.query); got != tc.value {
+			t.Errorf("%v: query %v = %q, want %q", tc.name, tc.query, got, tc.value)
+		}
+	}
+}
+
+func TestSnapshotFindErrors(t *testing.T) {
+	s := testSnapshot()
+	for _, f := range []Filter{{Status: "Up"}, {Prefix: "193.0.0.0"}} {
+		if _, err := s.Find(f); err == nil {
+			t.Errorf("Find(%+v) succeeded, want an error", f)
+		}
+	}
+}
+
+func TestLocateProbesFromSnapshot(t *testing.T) {
+	defer func(i Source) { Inventory = i }(Inventory)
+	Inventory = testSnapshot()
+	g := StaticGeocoder{"FRA": {50.03, 8.57}}
+
+	tests := []struct {
+		name     string
+		criteria Criteria
+		want     []int32
+		radius   int
+	}{
+		// 4 is not public, 3 is disconnected and 5 is elsewhere.
+		{name: "public and connected", criteria: Criteria{Metro: "FRA", Radius: 50, Count: 5}, want: []int32{1, 2}, radius: 50},
+		{name: "dual-stack", criteria: Criteria{Metro: "FRA", Radius: 50, Count: 5, AF: DualStack}, want: []int32{2}, radius: 50},
+		{name: "anchors", criteria: Criteria{Metro: "FRA", Radius: 50, Count: 5, Anchors: "require"}, want: []int32{2}, radius: 50},
+		{name: "widened", criteria: Criteria{Metro: "FRA", Radius: 5, MaxRadius: 50, RadiusStep: 5, Count: 2}, want: []int32{1, 2}, radius: 15},
+		// Uptime and connection time are measured to the snapshot's time,
+		// 2 connected a day before it was taken.
+		{name: "stability", criteria: Criteria{Metro: "FRA", Radius: 50, Count: 5,
+			Stability: &Stability{MinUptime: 0.9, MinConnected: 48 * time.Hour}}, want: []int32{1}, radius: 50},
+		{name: "stable first", criteria: Criteria{Metro: "FRA", Radius: 50, Count: 1, Select: "stable"}, want: []int32{1}, radius: 50},
+	}
+	for _, tc := range tests {
+		sel, err := LocateProbes(g, tc.criteria)
+		if err != nil {
+			t.Errorf("%v: LocateProbes() failed: %v", tc.name, err)
+			continue
+		}
+		if got := sel.Ids(); !reflect.DeepEqual(got, tc.want) || sel.Radius != tc.radius {
+			t.Errorf("%v: LocateProbes() = %v within %d km, want %v within %d km", tc.name, got, sel.Radius, tc.want, tc.radius)
+		}
+	}
+
+	if _, err := LocateProbes(g, Criteria{Metro: "FRA", Radius: 5, Count: 5, MinCount: 2}); err == nil {
+		t.Errorf("LocateProbes() with too few probes succeeded, want an error")
+	}
+}
@@ -0,0 +1,138 @@
+package probes
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Metros is the metro database used to locate metros, the bundled
+	// metros, with any loaded from a file.
+	Metros = bundledMetros()
+
+	//go:embed metros.csv
+	metrosBundle []byte
+)
+
+// Metro is a metro area, located at its center, with the airports which
+// serve it. A metro looked up by airport is that single airport.
+type Metro struct {
+	Code     string
+	City     string
+	Country  string
+	Lat      float64
+	Long     float64
+	Airports []string
+}
+
+// MetroDB maps metro codes (NYC) to metros.
+type MetroDB map[string]*Metro
+
+// bundledMetros parses the metros bundled with the package.
+func bundledMetros() MetroDB {
+	db := MetroDB{}
+	if err := db.read(bytes.NewReader(metrosBundle)); err != nil {
+		panic(fmt.Sprintf("bundled metros: %v", err))
+	}
+	return db
+}
+
+// Load reads metros from a CSV file of:
+// code,city,country,lat,long,airports
+// where airports is a space separated list of IATA codes. Metros loaded
+// replace those of the same code.
+func (db MetroDB) Load(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err := db.read(fd); err != nil {
+		return fmt.Errorf("metros(%v): %v", path, err)
+	}
+	return nil
+}
+
+func (db MetroDB) read(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 6
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m := &Metro{
+			Code:     strings.ToUpper(strings.TrimSpace(rec[0])),
+			City:     strings.TrimSpace(rec[1]),
+			Country:  strings.TrimSpace(rec[2]),
+			Airports: strings.Fields(strings.ToUpper(rec[5])),
+		}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(rec[3]), 64)
+		long, errLong := strconv.ParseFloat(strings.TrimSpace(rec[4]), 64)
+		if errLat != nil || errLong != nil {
+			return fmt.Errorf("bad location for %v", m.Code)
+		}
+		m.Lat, m.Long = lat, long
+		db[m.Code] = m
+	}
+}
+
+// Lookup finds a metro by, in order: metro code (NYC), airport IATA code
+// (JFK), airport ICAO code (KJFK) or city name (New York). Cities not in the
+// database are located at the center of their airports.
+func (db MetroDB) Lookup(name string) (*Metro, error) {
+	key := strings.ToUpper(strings.TrimSpace(name))
+	if m, ok := db[key]; ok {
+		return m, nil
+	}
+
+	aps, err := Airports.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find/parse the airports data: %v", err)
+	}
+	for _, a := range aps {
+		if strings.ToUpper(a.code) == key || strings.ToUpper(a.kcode) == key {
+			return airportMetro(key, []airport{a}), nil
+		}
+	}
+
+	for _, m := range db {
+		if strings.ToUpper(m.City) == key {
+			return m, nil
+		}
+	}
+	var city []airport
+	for _, a := range aps {
+		if strings.ToUpper(a.city) == key && (len(city) == 0 || a.country == city[0].country) {
+			city = append(city, a)
+		}
+	}
+	if len(city) > 0 {
+		return airportMetro(key, city), nil
+	}
+	return nil, fmt.Errorf("no metro, airport or city matches: %v", name)
+}
+
+// airportMetro creates a metro of airports in the same city, located at
+// their center.
+func airportMetro(code string, aps []airport) *Metro {
+	m := &Metro{Code: code, City: aps[0].city, Country: aps[0].country}
+	for _, a := range aps {
+		m.Lat += a.lat / float64(len(aps))
+		m.Long += a.long / float64(len(aps))
+		if a.code != "" && a.code != `\N` {
+			m.Airports = append(m.Airports, a.code)
+		}
+	}
+	return m
+}
@@ -10,46 +10,65 @@ import (
 	"github.com/morrowc/ripe-atlas/messages"
 )
 
-var (
-	Probes    = map[int32]messages.ProbeMessage{}
-	Countries = make(map[string]int)
-	prbUrl    = "https://atlas.ripe.net/api/v2/probes/%d/"
-)
+// Lookup queries the Inventory for the details of a set of probes. Probes
+// which it does not have are absent from the result.
+func Lookup(ids []int32) (map[int32]messages.ProbeMessage, error) {
+	res := map[int32]messages.ProbeMessage{}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	ps, err := Inventory.Find(Filter{Ids: ids})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		res[p.Id] = p
+	}
+	return res, nil
+}
 
-// GatherProbe queries RIPE for probe specific data, only if the probe has
-// not been seen prior. Reads probes to query from chan ic, sends results
-// on chan oc.
-func GatherProbe(ic chan int32, oc chan messages.ProbeMessage) {
-	for pi := range ic {
-		// Check the current probe map, if the probe isn't here, go get data
-		// about the probe.
-		_, ok := Probes[pi]
-		if !ok {
-			u := fmt.Sprintf(prbUrl, pi)
-			response, err := http.Get(u)
-			if err != nil {
-				fmt.Printf("Failed to query probe id(%d): %v\n", pi, err)
-				continue
-			}
-			defer response.Body.Close()
+func getProbePage(u string) (*messages.ProbeQueryResults, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the RIPE Probe Request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the RIPE Probe Request(%v): %v", u, resp.Status)
+	}
 
-			c, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				fmt.Printf("Failed to read probe id request body(%d): %v\n", pi, err)
-				continue
-			}
-			m := messages.ProbeMessage{}
-			err = json.Unmarshal(c, &m)
-			if err != nil {
-				fmt.Printf("Failed to parse the probe message json(%d): %v\n", pi, err)
-			}
-			_, ok := Countries[m.CountryCode]
-			if !ok {
-				Countries[m.CountryCode] = 0
-			} else {
-				Countries[m.CountryCode]++
-			}
-			oc <- m
+	c, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response Body: %v", err)
+	}
+	var pq messages.ProbeQueryResults
+	if err := json.Unmarshal(c, &pq); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the json probe data: %v", err)
+	}
+	return &pq, nil
+}
+
+// Attribute returns the named attribute of a probe, used to group results:
+// country, asn or prefix. The asn and prefix follow the address family (af)
+// of the measurement.
+func Attribute(p messages.ProbeMessage, name string, af int) (string, error) {
+	switch name {
+	case "country":
+		return p.CountryCode, nil
+	case "asn":
+		asn := p.ASNv4
+		if af == 6 {
+			asn = p.ASNv6
+		}
+		if asn == 0 {
+			return "", nil
+		}
+		return fmt.Sprintf("AS%d", asn), nil
+	case "prefix":
+		if af == 6 {
+			return p.PrefixV6, nil
 		}
+		return p.PrefixV4, nil
 	}
+	return "", fmt.Errorf("unknown probe attribute(%v), use country, asn or prefix", name)
 }
@@ -0,0 +1,136 @@
+package probes
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Strategy chooses up to count probes from the candidates found around a
+// metro located at lat, long. now is the time the candidates' details are
+// from, the Inventory's Now.
+type Strategy interface {
+	Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage
+}
+
+// ById selects the probes with the lowest ids.
+type ById struct{}
+
+// Select returns the first count probes, sorted by id.
+func (ById) Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage {
+	res := append([]messages.ProbeMessage(nil), ps...)
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return first(res, count)
+}
+
+// Nearest selects the probes nearest the metro.
+type Nearest struct{}
+
+// Select returns the count probes nearest lat, long. Probes without a
+// location sort last.
+func (Nearest) Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage {
+	return first(byDistance(ps, lat, long), count)
+}
+
+// Random selects probes at random, the same probes for the same Seed and
+// candidates.
+type Random struct {
+	Seed int64
+}
+
+// Select returns count probes chosen at random.
+func (r Random) Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage {
+	res := append([]messages.ProbeMessage(nil), ps...)
+	// Shuffle from a fixed order, as RIPE's order may change.
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	rnd := rand.New(rand.NewSource(r.Seed))
+	rnd.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	return first(res, count)
+}
+
+// Diverse selects probes from as many networks as possible: one probe from
+// each network, nearest first, before a second from any. Networks are the
+// probe Attribute named Key (asn or prefix) for address family Af.
+type Diverse struct {
+	Key string
+	Af  int
+}
+
+// Select returns count probes, spread over the most networks.
+func (d Diverse) Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage {
+	var order []string
+	networks := map[string][]messages.ProbeMessage{}
+	for _, p := range byDistance(ps, lat, long) {
+		k, _ := Attribute(p, d.Key, d.Af)
+		if k == "" {
+			// Probes of an unknown network are each their own network.
+			k = fmt.Sprintf("probe-%d", p.Id)
+		}
+		if _, ok := networks[k]; !ok {
+			order = append(order, k)
+		}
+		networks[k] = append(networks[k], p)
+	}
+
+	var res []messages.ProbeMessage
+	for len(res) < len(ps) && len(res) < count {
+		for _, k := range order {
+			if len(networks[k]) > 0 && len(res) < count {
+				res = append(res, networks[k][0])
+				networks[k] = networks[k][1:]
+			}
+		}
+	}
+	return res
+}
+
+// NewStrategy returns the named strategy: id, nearest, stable, random (with
+// seed), asn or prefix (diverse for address family af).
+func NewStrategy(name string, af int, seed int64) (Strategy, error) {
+	switch name {
+	case "id":
+		return ById{}, nil
+	case "nearest":
+		return Nearest{}, nil
+	case "stable":
+		return Stable{}, nil
+	case "random":
+		return Random{Seed: seed}, nil
+	case "asn", "prefix":
+		return Diverse{Key: name, Af: af}, nil
+	}
+	return nil, fmt.Errorf("unknown selection strategy(%v), use id, nearest, stable, random, asn or prefix", name)
+}
+
+// byDistance returns the probes sorted by distance from lat, long, then id.
+func byDistance(ps []messages.ProbeMessage, lat, long float64) []messages.ProbeMessage {
+	dist := map[int32]float64{}
+	for _, p := range ps {
+		pLat, pLong, ok := Location(p)
+		if !ok {
+			dist[p.Id] = math.Inf(1)
+			continue
+		}
+		dist[p.Id] = Distance(lat, long, pLat, pLong)
+	}
+	res := append([]messages.ProbeMessage(nil), ps...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if dist[res[i].Id] != dist[res[j].Id] {
+			return dist[res[i].Id] < dist[res[j].Id]
+		}
+		return res[i].Id < res[j].Id
+	})
+	return res
+}
+
+// first returns up to the first count probes.
+func first(ps []messages.ProbeMessage, count int) []messages.ProbeMessage {
+	if len(ps) > count {
+		return ps[:count]
+	}
+	return ps
+}
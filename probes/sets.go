@@ -0,0 +1,184 @@
+package probes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProbeSet is a named set of probes, saved to disk, so paired measurements
+// (v4 and v6, recursive and not) are made from the same probes.
+type ProbeSet struct {
+	Name     string   `json:"name"`
+	Criteria Criteria `json:"criteria"`
+	// Radius is the radius, in km, the probes were found within.
+	Radius  int       `json:"radius"`
+	Probes  []int32   `json:"probes"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// SetsDir returns the default directory probe sets are saved in,
+// $XDG_CONFIG_HOME/ripe-atlas/probe-sets.
+func SetsDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ripe-atlas", "probe-sets")
+}
+
+// setPath returns the path of the named set in dir. Names are file names
+// within dir, so they can not be empty or hold a path.
+func setPath(dir, name string) (string, error) {
+	if name == "" || name == "." || strings.Contains(name, "..") ||
+		strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, os.PathSeparator) {
+		return "", fmt.Errorf("bad probe set name(%v): it must not be empty, or hold a path", name)
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// NewSet locates the probes matching the criteria, as a new set.
+func NewSet(g Geocoder, name string, c Criteria) (*ProbeSet, error) {
+	if _, err := setPath("", name); err != nil {
+		return nil, err
+	}
+	sel, err := LocateProbes(g, c)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &ProbeSet{
+		Name:     name,
+		Criteria: c,
+		Radius:   sel.Radius,
+		Probes:   sel.Ids(),
+		Created:  now,
+		Updated:  now,
+	}, nil
+}
+
+// LoadSet reads the named set from dir.
+func LoadSet(dir, name string) (*ProbeSet, error) {
+	path, err := setPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s ProbeSet
+	if err := json.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse the probe set(%v): %v", name, err)
+	}
+	return &s, nil
+}
+
+// Save writes the set to dir, replacing any set of the same name.
+func (s *ProbeSet) Save(dir string) error {
+	path, err := setPath(dir, s.Name)
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeAtomic(path, append(body, '\n'))
+}
+
+// Refresh replaces the members which no longer match the set's criteria,
+// disconnected probes, with new probes matching the criteria. The other
+// members are kept. The ids of the members removed and added are returned.
+// Probes are matched at the inventory's time, a snapshot's when one is used,
+// but Updated is when the set was refreshed.
+func (s *ProbeSet) Refresh(g Geocoder) ([]int32, []int32, error) {
+	details, err := Lookup(s.Probes)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := Inventory.Now()
+	var kept, removed []int32
+	for _, id := range s.Probes {
+		p, ok := details[id]
+		if ok {
+			if ok, err = s.Criteria.Match(p, now); err != nil {
+				return nil, nil, err
+			}
+		}
+		if ok {
+			kept = append(kept, id)
+		} else {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil, nil
+	}
+
+	// Replacing only some of the members is better than none.
+	c := s.Criteria
+	c.Count = len(removed)
+	c.MinCount = 0
+	c.Exclude = s.Probes
+	sel, err := LocateProbes(g, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	added := sel.Ids()
+	if sel.Radius > s.Radius {
+		s.Radius = sel.Radius
+	}
+	s.Probes = append(kept, added...)
+	sort.Slice(s.Probes, func(i, j int) bool { return s.Probes[i] < s.Probes[j] })
+	s.Updated = time.Now().UTC()
+	return removed, added, nil
+}
+
+// Differences returns the names, as in a saved set, of the criteria which
+// differ from o's.
+func (c Criteria) Differences(o Criteria) []string {
+	stability := func(s *Stability) string {
+		if s == nil {
+			s = &Stability{}
+		}
+		return fmt.Sprintf("%v %v %v", s.MinUptime, s.MinConnected, strings.Join(s.Tags, ","))
+	}
+	af := func(af string) string {
+		if af == "" {
+			return V4Capable
+		}
+		return af
+	}
+	var res []string
+	for _, d := range []struct {
+		name string
+		same bool
+	}{
+		{"metro", strings.EqualFold(c.Metro, o.Metro)},
+		{"radius", c.Radius == o.Radius},
+		{"max_radius", c.MaxRadius == o.MaxRadius},
+		{"radius_step", c.RadiusStep == o.RadiusStep},
+		{"count", c.Count == o.Count},
+		{"min_count", c.MinCount == o.MinCount},
+		{"af", af(c.AF) == af(o.AF)},
+		{"anchors", c.Anchors == o.Anchors},
+		{"stability", stability(c.Stability) == stability(o.Stability)},
+		{"select", c.Select == o.Select},
+		{"seed", c.Seed == o.Seed},
+	} {
+		if !d.same {
+			res = append(res, d.name)
+		}
+	}
+	return res
+}
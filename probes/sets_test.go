@@ -0,0 +1,72 @@
+package probes
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"", ".", "..", "../fra", "a/b", `a\b`, "fra..v6"} {
+		if _, err := LoadSet(dir, name); err == nil || os.IsNotExist(err) {
+			t.Errorf("LoadSet(%q) = %v, want a bad name error", name, err)
+		}
+		if err := (&ProbeSet{Name: name}).Save(dir); err == nil {
+			t.Errorf("Save() of %q succeeded, want an error", name)
+		}
+		if _, err := NewSet(StaticGeocoder{}, name, Criteria{}); err == nil {
+			t.Errorf("NewSet(%q) succeeded, want an error", name)
+		}
+	}
+
+	s := &ProbeSet{Name: "fra-dual.v2", Criteria: Criteria{Metro: "FRA", AF: DualStack}, Probes: []int32{1, 2}}
+	if err := s.Save(dir); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	got, err := LoadSet(dir, s.Name)
+	if err != nil || !reflect.DeepEqual(got, s) {
+		t.Errorf("LoadSet() = %+v, %v, want %+v", got, err, s)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	defer func(i Source) { Inventory = i }(Inventory)
+	Inventory = testSnapshot()
+	g := StaticGeocoder{"FRA": {50.03, 8.57}}
+
+	// 3 has disconnected since the set was made.
+	s := &ProbeSet{Name: "fra", Criteria: Criteria{Metro: "FRA", Radius: 50, Count: 2}, Probes: []int32{1, 3}}
+	before := time.Now()
+	removed, added, err := s.Refresh(g)
+	if err != nil {
+		t.Fatalf("Refresh() failed: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []int32{3}) || !reflect.DeepEqual(added, []int32{2}) ||
+		!reflect.DeepEqual(s.Probes, []int32{1, 2}) {
+		t.Errorf("Refresh() removed %v added %v, members %v, want 3, 2 and [1 2]", removed, added, s.Probes)
+	}
+	// Probes are matched at the snapshot's time, but the set was updated now.
+	if s.Updated.Before(before) {
+		t.Errorf("Refresh() updated = %v, want after %v", s.Updated, before)
+	}
+}
+
+func TestDifferences(t *testing.T) {
+	c := Criteria{Metro: "FRA", Radius: 10, Count: 5, Stability: &Stability{}}
+	tests := []struct {
+		o    Criteria
+		want []string
+	}{
+		{Criteria{Metro: "fra", Radius: 10, Count: 5, AF: V4Capable}, nil},
+		{Criteria{Metro: "AMS", Radius: 10, Count: 5, AF: DualStack}, []string{"metro", "af"}},
+		{Criteria{Metro: "FRA", Radius: 10, Count: 5, Select: "asn", Stability: &Stability{Tags: []string{"system-ipv6-works"}}},
+			[]string{"stability", "select"}},
+	}
+	for _, tc := range tests {
+		if got := c.Differences(tc.o); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Differences(%+v) = %v, want %v", tc.o, got, tc.want)
+		}
+	}
+}
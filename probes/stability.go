@@ -0,0 +1,132 @@
+package probes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+const (
+	// settled is how long a probe must have been connected to score fully,
+	// probes connected more recently score less.
+	settled = 30 * 24 * time.Hour
+)
+
+// Stability filters candidate probes on their history and system tags.
+// Probes which are not StableOver the address families they are selected
+// for are always excluded.
+type Stability struct {
+	// MinUptime is the smallest fraction of the time since the probe first
+	// connected that it must have been connected.
+	MinUptime float64 `json:"min_uptime"`
+	// MinConnected is how long the probe must have been connected.
+	MinConnected time.Duration `json:"min_connected"`
+	// Tags are the tags, such as system-ipv6-works, the probe must have.
+	Tags []string `json:"tags"`
+}
+
+// Uptime returns the fraction of the time since the probe first connected
+// which it has been connected.
+func Uptime(p messages.ProbeMessage, now time.Time) float64 {
+	life := now.Unix() - int64(p.FirstConnected)
+	if p.FirstConnected == 0 || life <= 0 {
+		return 0
+	}
+	u := float64(p.TotalUptime) / float64(life)
+	if u > 1 {
+		u = 1
+	}
+	return u
+}
+
+// Connected returns how long the probe has been connected, zero if it is
+// not connected.
+func Connected(p messages.ProbeMessage, now time.Time) time.Duration {
+	if p.Status.Name != "Connected" || p.StatusSince == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(int64(p.StatusSince), 0))
+}
+
+// Score rates a probe from 0 to 1: its uptime, reduced for probes connected
+// for less than 30 days.
+func Score(p messages.ProbeMessage, now time.Time) float64 {
+	c := float64(Connected(p, now)) / float64(settled)
+	if c > 1 {
+		c = 1
+	}
+	return Uptime(p, now) * c
+}
+
+// HasTag reports whether the probe has the tag, by slug.
+func HasTag(p messages.ProbeMessage, tag string) bool {
+	for _, t := range p.Tags {
+		if t.Slug == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// StableOver reports whether RIPE tags the probe stable over address family
+// af: system-ipv4-stable-1d, system-ipv6-stable-30d and so on.
+func StableOver(p messages.ProbeMessage, af int) bool {
+	prefix := fmt.Sprintf("system-ipv%d-stable-", af)
+	for _, t := range p.Tags {
+		if strings.HasPrefix(t.Slug, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the probes which pass the filter, for the address family
+// mode.
+func (s *Stability) Filter(ps []messages.ProbeMessage, mode string, now time.Time) []messages.ProbeMessage {
+	var res []messages.ProbeMessage
+	for _, p := range ps {
+		if s.Pass(p, mode, now) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Pass reports whether a single probe passes the filter, for the address
+// family mode: it must be StableOver each family the mode needs.
+func (s *Stability) Pass(p messages.ProbeMessage, mode string, now time.Time) bool {
+	for _, af := range ModeAFs(mode) {
+		if !StableOver(p, af) {
+			return false
+		}
+	}
+	if Uptime(p, now) < s.MinUptime || Connected(p, now) < s.MinConnected {
+		return false
+	}
+	for _, t := range s.Tags {
+		if !HasTag(p, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stable selects the probes with the highest Score.
+type Stable struct{}
+
+// Select returns the count highest scoring probes at now, by id on equal
+// scores.
+func (Stable) Select(ps []messages.ProbeMessage, count int, lat, long float64, now time.Time) []messages.ProbeMessage {
+	res := append([]messages.ProbeMessage(nil), ps...)
+	sort.SliceStable(res, func(i, j int) bool {
+		si, sj := Score(res[i], now), Score(res[j], now)
+		if si != sj {
+			return si > sj
+		}
+		return res[i].Id < res[j].Id
+	})
+	return first(res, count)
+}
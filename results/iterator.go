@@ -0,0 +1,153 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// DecodeError reports a single result record which could not be decoded.
+// The stream remains usable, calling Next again skips the bad record.
+type DecodeError struct {
+	Index int
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed decoding result record %d: %v", e.Index, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Iterator streams measurement results from a JSON array of results,
+// decoding one record at a time. Usage follows bufio.Scanner:
+//
+//	it := results.NewIterator(r)
+//	for it.Next() {
+//		rec := it.Result()
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// A *DecodeError from Err may be skipped by calling Next again, any other
+// error ends the stream.
+type Iterator struct {
+	dec     *json.Decoder
+	closer  io.Closer
+	started bool
+	done    bool
+	index   int
+	cur     messages.MeasurementResultMessage
+	err     error
+}
+
+// NewIterator returns an Iterator reading results from r.
+func NewIterator(r io.Reader) *Iterator {
+	return &Iterator{dec: json.NewDecoder(r)}
+}
+
+// Fetch requests the results of measurement mid which match the query,
+// returning an Iterator over the response. The Iterator must be closed.
+func Fetch(mid int, q *Query) (*Iterator, error) {
+	if err := q.Check(); err != nil {
+		return nil, err
+	}
+	u := q.URL(mid)
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get results(%v): %v", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get results(%v): %v", u, resp.Status)
+	}
+	it := NewIterator(resp.Body)
+	it.closer = resp.Body
+	return it, nil
+}
+
+// Next advances to the next result, which is then available from Result.
+// It returns false at the end of the stream, or on error.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	it.err = nil
+	if !it.started {
+		it.started = true
+		// Read the opening bracket of the result array.
+		t, err := it.dec.Token()
+		if err != nil {
+			return it.fail(fmt.Errorf("reading the initial token failed: %v", err))
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return it.fail(fmt.Errorf("results are not a list, got: %v", t))
+		}
+	}
+	if !it.dec.More() {
+		it.done = true
+		return false
+	}
+
+	// Split the record from the stream first, so a record which does not fit
+	// the result struct can be skipped while the stream continues.
+	var raw json.RawMessage
+	if err := it.dec.Decode(&raw); err != nil {
+		return it.fail(fmt.Errorf("failed reading result record %d: %v", it.index, err))
+	}
+	it.index++
+	it.cur = messages.MeasurementResultMessage{}
+	if err := json.Unmarshal(raw, &it.cur); err != nil {
+		it.err = &DecodeError{Index: it.index - 1, Err: err}
+		return false
+	}
+	return true
+}
+
+// Result returns the result decoded by the last successful call to Next.
+func (it *Iterator) Result() messages.MeasurementResultMessage {
+	return it.cur
+}
+
+// Err returns the error which stopped Next, or nil at a clean end of stream.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Each calls fn for every result in the stream. Records which fail to decode
+// are passed to skip and the stream continues, if skip is nil they end the
+// stream with their error.
+func (it *Iterator) Each(fn func(messages.MeasurementResultMessage), skip func(*DecodeError)) error {
+	for {
+		if it.Next() {
+			fn(it.Result())
+			continue
+		}
+		var de *DecodeError
+		if skip != nil && errors.As(it.err, &de) {
+			skip(de)
+			continue
+		}
+		return it.err
+	}
+}
+
+// Close releases the underlying response body, if the Iterator came from Fetch.
+func (it *Iterator) Close() error {
+	it.done = true
+	if it.closer != nil {
+		return it.closer.Close()
+	}
+	return nil
+}
+
+func (it *Iterator) fail(err error) bool {
+	it.err = err
+	it.done = true
+	return false
+}
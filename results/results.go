@@ -0,0 +1,135 @@
+// results implements fetching of measurement results from the RIPE atlas system.
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+const (
+	measurementURL = "https://atlas.ripe.net/api/v2/measurements/%d/"
+	resultsURL     = "https://atlas.ripe.net/api/v2/measurements/%d/results/"
+	latestURL      = "https://atlas.ripe.net/api/v2/measurements/%d/latest/"
+	timeFmt        = "2006-01-02T15:04Z"
+)
+
+// Query describes the subset of a measurement's results to fetch.
+// Zero values select everything: all time and all probes.
+type Query struct {
+	Start    time.Time
+	Stop     time.Time
+	ProbeIds []int32
+	// Latest selects only the most recent result from each probe, using
+	// the latest-results endpoint, which takes no time window: Check rejects
+	// a Latest query with a Start or Stop.
+	Latest bool
+}
+
+// Check reports whether the query is consistent.
+func (q *Query) Check() error {
+	if q.Latest && (!q.Start.IsZero() || !q.Stop.IsZero()) {
+		return fmt.Errorf("the latest results can not be limited to a time window, drop the start and stop")
+	}
+	return nil
+}
+
+// URL returns the results URL for the measurement, with the query parameters
+// which implement the Query.
+func (q *Query) URL(mid int) string {
+	v := url.Values{}
+	if len(q.ProbeIds) > 0 {
+		var ids []string
+		for _, id := range q.ProbeIds {
+			ids = append(ids, strconv.Itoa(int(id)))
+		}
+		v.Set("probe_ids", strings.Join(ids, ","))
+	}
+
+	u := fmt.Sprintf(latestURL, mid)
+	if !q.Latest {
+		u = fmt.Sprintf(resultsURL, mid)
+		if !q.Start.IsZero() {
+			v.Set("start", strconv.FormatInt(q.Start.Unix(), 10))
+		}
+		if !q.Stop.IsZero() {
+			v.Set("stop", strconv.FormatInt(q.Stop.Unix(), 10))
+		}
+	}
+	v.Set("format", "json")
+	return u + "?" + v.Encode()
+}
+
+// Measurement requests the details of measurement mid.
+func Measurement(mid int) (*messages.MeasurementResponseMessage, error) {
+	u := fmt.Sprintf(measurementURL, mid)
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get measurement(%v): %v", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get measurement(%v): %v", u, resp.Status)
+	}
+
+	// Read all of the details (this is minimal data volume, kbytes)
+	c, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read measurement(%v): %v", u, err)
+	}
+	var m messages.MeasurementResponseMessage
+	if err := json.Unmarshal(c, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the measurement(%v): %v", u, err)
+	}
+	return &m, nil
+}
+
+// ParseTime converts a command-line time into a time.Time. Accepted forms are:
+// unix seconds (1546300800), RFC3339 (2019-01-01T00:00:00Z), the measurement
+// time format (2019-01-01T00:00Z) or a negative duration relative to now (-1h).
+// An empty string returns the zero time.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse relative time(%v): %v", s, err)
+		}
+		return now.Add(d), nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	for _, f := range []string{time.RFC3339, timeFmt} {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse time(%v), use unix seconds, RFC3339 or -duration", s)
+}
+
+// ParseIds converts a comma separated list of probe or measurement ids into a slice.
+func ParseIds(s string) ([]int32, error) {
+	var res []int32
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(f, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id(%v): %v", f, err)
+		}
+		res = append(res, int32(id))
+	}
+	return res, nil
+}
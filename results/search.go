@@ -0,0 +1,61 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+const (
+	searchURL = "https://atlas.ripe.net:443/api/v2/measurements/"
+)
+
+// Search requests the measurements which carry all of the comma separated
+// tags and whose description contains description, following the result
+// pages until all are read. Either criteria may be empty.
+func Search(tags, description string) ([]messages.MeasurementResponseMessage, error) {
+	v := url.Values{}
+	if tags != "" {
+		v.Set("tags", tags)
+	}
+	if description != "" {
+		v.Set("description__contains", description)
+	}
+	u := searchURL + "?" + v.Encode()
+
+	var res []messages.MeasurementResponseMessage
+	for u != "" {
+		m, err := searchPage(u)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m.Results...)
+		u = m.Next
+	}
+	return res, nil
+}
+
+func searchPage(u string) (*messages.MultiMeasurementResponseMessage, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get measurement data from ripe: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get measurement data from ripe(%v): %v", u, resp.Status)
+	}
+
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var m messages.MultiMeasurementResponseMessage
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse some json: %v", err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,328 @@
+// slo evaluates measurement results against service level objectives, read
+// from a JSON config file such as:
+//
+//	{"objectives": [
+//	  {"name": "dns-p95", "type": "dns", "metric": "p95", "max": 50,
+//	   "group": "metro", "window": "1h"},
+//	  {"name": "dns-errors", "type": "dns", "metric": "failure_rate", "max": 0.01,
+//	   "group": "metro", "window": "1h"}
+//	]}
+//
+// which reads: p95 DNS rt below 50ms, and fewer than 1% of queries failing,
+// per metro per hour.
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/probes"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+// Objective is a single threshold which each group of results, in each
+// window, must stay below.
+type Objective struct {
+	Name string `json:"name"`
+	// Type limits the objective to one measurement type, such as dns.
+	Type string `json:"type"`
+	// Metric is one of: min, max, mean, p50, p90, p95, p99, timeout_rate,
+	// error_rate, failure_rate, loss. Latencies are in milliseconds, rates
+	// are fractions.
+	Metric string  `json:"metric"`
+	Max    float64 `json:"max"`
+	// Group is measurement, metro, probe, or a probe attribute: country,
+	// asn or prefix.
+	Group string `json:"group"`
+	// Window is the time window each group is evaluated over, such as 1h.
+	// Without a window each group is evaluated over all its results.
+	Window string `json:"window"`
+	// MinCount is the fewest samples a group needs, in a window, to be
+	// evaluated. Windows with fewer, or none, are violations for lack of
+	// data.
+	MinCount int `json:"min_count"`
+
+	window time.Duration
+}
+
+// Config is the set of objectives in a config file.
+type Config struct {
+	Objectives []Objective `json:"objectives"`
+}
+
+// Load reads and checks the objectives of a config file.
+func Load(path string) (*Config, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse the slo config(%v): %v", path, err)
+	}
+	for i := range c.Objectives {
+		if err := c.Objectives[i].check(); err != nil {
+			return nil, fmt.Errorf("slo config(%v): %v", path, err)
+		}
+	}
+	return &c, nil
+}
+
+// check validates the objective, and parses its window.
+func (o *Objective) check() error {
+	known := false
+	for _, m := range metrics {
+		known = known || m == o.Metric
+	}
+	if !known {
+		return fmt.Errorf("objective(%v): unknown metric: %v", o.Name, o.Metric)
+	}
+	switch o.Group {
+	case "", "measurement", "metro", "probe":
+	default:
+		if _, err := probes.Attribute(messages.ProbeMessage{}, o.Group, 4); err != nil {
+			return fmt.Errorf("objective(%v): %v", o.Name, err)
+		}
+	}
+	if o.Window != "" {
+		w, err := time.ParseDuration(o.Window)
+		if err != nil {
+			return fmt.Errorf("objective(%v): bad window: %v", o.Name, err)
+		}
+		if w < time.Second {
+			return fmt.Errorf("objective(%v): window(%v) is shorter than a second", o.Name, w)
+		}
+		o.window = w
+	}
+	return nil
+}
+
+// metrics are the names of the metrics an objective can set a threshold on.
+var metrics = []string{
+	"min", "max", "mean", "p50", "p90", "p95", "p99",
+	"timeout_rate", "error_rate", "failure_rate", "loss",
+}
+
+// metric returns the named metric of a summary, and whether it has a value.
+// Latency metrics have no value when no sample received an answer.
+func metric(s stats.Summary, name string) (float64, bool) {
+	answered := s.Count-s.Timeouts-s.Errors > 0
+	switch name {
+	case "min":
+		return s.Min, answered
+	case "max":
+		return s.Max, answered
+	case "mean":
+		return s.Mean, answered
+	case "p50":
+		return s.P50, answered
+	case "p90":
+		return s.P90, answered
+	case "p95":
+		return s.P95, answered
+	case "p99":
+		return s.P99, answered
+	case "timeout_rate":
+		return s.TimeoutRate(), true
+	case "error_rate":
+		return s.ErrorRate(), true
+	case "failure_rate":
+		return s.FailureRate(), true
+	case "loss":
+		return s.Loss(), s.Sent > 0
+	}
+	return 0, false
+}
+
+// Violation is a group, in a window, whose metric exceeded its objective,
+// or which had no value to evaluate: no results, fewer than MinCount, or
+// none answered.
+type Violation struct {
+	Objective Objective
+	Group     string
+	// Start is the start of the window, zero when the objective has none.
+	Start time.Time
+	Value float64
+	Count int
+	// NoData is set when the metric has no value, so the objective can not
+	// be shown to be met.
+	NoData bool
+}
+
+func (v Violation) String() string {
+	o := v.Objective
+	when := "all"
+	if !v.Start.IsZero() {
+		when = v.Start.UTC().Format(time.RFC3339)
+	}
+	if v.NoData {
+		return fmt.Sprintf("%v %v: %v %v %v: no data (count: %d)",
+			o.Name, o.Group, v.Group, when, o.Metric, v.Count)
+	}
+	return fmt.Sprintf("%v %v: %v %v %v: %0.3f > %v (count: %d)",
+		o.Name, o.Group, v.Group, when, o.Metric, v.Value,
+		strconv.FormatFloat(o.Max, 'f', -1, 64), v.Count)
+}
+
+// result holds the samples of a single measurement result.
+type result struct {
+	prbId      int32
+	timestamp  int32
+	samples    []stats.Sample
+	sent, rcvd int
+}
+
+// Evaluator accumulates the results of a measurement, to be evaluated
+// against the objectives once all are added.
+type Evaluator struct {
+	Objectives []Objective
+	results    []result
+}
+
+// NewEvaluator creates an Evaluator for the objectives of a config.
+func NewEvaluator(c *Config) *Evaluator {
+	return &Evaluator{Objectives: c.Objectives}
+}
+
+// Add records a single result.
+func (e *Evaluator) Add(m messages.MeasurementResultMessage) {
+	r := result{prbId: m.PrbId, timestamp: m.Timestamp, samples: stats.Samples(m)}
+	if m.Type == "ping" {
+		r.sent, r.rcvd = m.Sent, m.Rcvd
+	}
+	e.results = append(e.results, r)
+}
+
+// Evaluate checks the results added, of measurement m, against each
+// objective and returns the violations. The metro of the measurement and
+// the probe details are used by the metro and probe attribute groups.
+// Without any results each objective is violated, for lack of data.
+func (e *Evaluator) Evaluate(m messages.MeasurementResponseMessage, metro string, details map[int32]messages.ProbeMessage) []Violation {
+	var res []Violation
+	for _, o := range e.Objectives {
+		if o.Type != "" && o.Type != m.Type {
+			continue
+		}
+		if len(e.results) == 0 {
+			res = append(res, Violation{Objective: o, Group: "all", NoData: true})
+			continue
+		}
+		key := func(id int32) string {
+			switch o.Group {
+			case "", "measurement":
+				return strconv.Itoa(int(m.Id))
+			case "metro":
+				return metro
+			case "probe":
+				return strconv.Itoa(int(id))
+			}
+			p, ok := details[id]
+			if !ok {
+				return ""
+			}
+			k, _ := probes.Attribute(p, o.Group, m.Af)
+			return k
+		}
+		res = append(res, e.evaluate(o, key)...)
+	}
+	return res
+}
+
+// evaluate checks a single objective, with results grouped by key. Windows
+// in which a group has no results are evaluated with a Count of 0, so they,
+// and those with fewer than MinCount samples or without an answered sample,
+// are violations for lack of data.
+func (e *Evaluator) evaluate(o Objective, key func(int32) string) []Violation {
+	groups := map[string][]stats.Bucket{}
+	var res []Violation
+	if o.window > 0 {
+		series := map[string]*stats.Series{}
+		for _, r := range e.results {
+			k := groupKey(key(r.prbId))
+			s, ok := series[k]
+			if !ok {
+				s = stats.NewSeries(o.window)
+				series[k] = s
+			}
+			for _, smp := range r.samples {
+				s.Add(smp)
+			}
+			if r.sent > 0 {
+				s.AddPackets(r.timestamp, r.sent, r.rcvd)
+			}
+		}
+		var first, last time.Time
+		for k, s := range series {
+			groups[k] = s.Buckets()
+			for _, b := range groups[k] {
+				if first.IsZero() || b.Start.Before(first) {
+					first = b.Start
+				}
+				if b.Start.After(last) {
+					last = b.Start
+				}
+			}
+		}
+		// Windows, within the results' span, in which a group has no results.
+		for k, buckets := range groups {
+			have := map[time.Time]bool{}
+			for _, b := range buckets {
+				have[b.Start] = true
+			}
+			for t := first; !t.After(last); t = t.Add(o.window) {
+				if !have[t] {
+					groups[k] = append(groups[k], stats.Bucket{Start: t})
+				}
+			}
+		}
+	} else {
+		values := map[string]*stats.Values{}
+		for _, r := range e.results {
+			k := groupKey(key(r.prbId))
+			v, ok := values[k]
+			if !ok {
+				v = &stats.Values{}
+				values[k] = v
+			}
+			for _, smp := range r.samples {
+				v.Add(smp)
+			}
+			v.AddPackets(r.sent, r.rcvd)
+		}
+		for k, v := range values {
+			groups[k] = []stats.Bucket{{Summary: v.Summary()}}
+		}
+	}
+
+	for k, buckets := range groups {
+		for _, b := range buckets {
+			v, ok := metric(b.Summary, o.Metric)
+			switch {
+			case !ok, b.Count == 0, b.Count < o.MinCount:
+				res = append(res, Violation{Objective: o, Group: k, Start: b.Start, Count: b.Count, NoData: true})
+			case v > o.Max:
+				res = append(res, Violation{Objective: o, Group: k, Start: b.Start, Value: v, Count: b.Count})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Group != res[j].Group {
+			return res[i].Group < res[j].Group
+		}
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res
+}
+
+// groupKey names the group of results without a key, as GroupBy does.
+func groupKey(k string) string {
+	if k == "" {
+		return "unknown"
+	}
+	return k
+}
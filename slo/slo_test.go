@@ -0,0 +1,134 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+	"github.com/morrowc/ripe-atlas/stats"
+)
+
+// hour is the start of an hour long window, 2019-01-06 09:00 UTC.
+const hour = 1546765200
+
+// rtts returns a result of probe 1, at hour h after the first, with a
+// sample per latency. A negative latency is a timeout.
+func rtts(h int, vs ...float64) result {
+	r := result{prbId: 1, timestamp: int32(hour + h*3600)}
+	for _, v := range vs {
+		s := stats.Sample{PrbId: r.prbId, Timestamp: r.timestamp, Rtt: v}
+		if v < 0 {
+			s = stats.Sample{PrbId: r.prbId, Timestamp: r.timestamp, Timeout: true}
+		}
+		r.samples = append(r.samples, s)
+	}
+	return r
+}
+
+func TestEvaluate(t *testing.T) {
+	type violation struct {
+		h      int // window, hours after the first
+		value  float64
+		count  int
+		noData bool
+	}
+	tests := []struct {
+		name    string
+		o       Objective
+		results []result
+		want    []violation
+	}{
+		{
+			name:    "below the threshold",
+			o:       Objective{Metric: "p95", Max: 50, Window: "1h"},
+			results: []result{rtts(0, 10, 20, 30), rtts(1, 40, 50)},
+		},
+		{
+			name:    "above the threshold",
+			o:       Objective{Metric: "p95", Max: 50, Window: "1h"},
+			results: []result{rtts(0, 10, 20, 30), rtts(1, 40, 60)},
+			want:    []violation{{h: 1, value: 60, count: 2}},
+		},
+		{
+			name:    "empty window",
+			o:       Objective{Metric: "p95", Max: 50, Window: "1h"},
+			results: []result{rtts(0, 10), rtts(2, 10)},
+			want:    []violation{{h: 1, noData: true}},
+		},
+		{
+			// Rates have a value without answers, but not without samples.
+			name:    "empty window of a rate",
+			o:       Objective{Metric: "failure_rate", Max: 0.5, Window: "1h"},
+			results: []result{rtts(0, 10), rtts(2, 10)},
+			want:    []violation{{h: 1, noData: true}},
+		},
+		{
+			name:    "no answers",
+			o:       Objective{Metric: "p50", Max: 50, Window: "1h"},
+			results: []result{rtts(0, 10), rtts(1, -1, -1)},
+			want:    []violation{{h: 1, count: 2, noData: true}},
+		},
+		{
+			name:    "min_count",
+			o:       Objective{Metric: "p95", Max: 50, Window: "1h", MinCount: 3},
+			results: []result{rtts(0, 10, 20, 30), rtts(1, 10, 20), rtts(3, 60, 60, 60)},
+			want:    []violation{{h: 1, count: 2, noData: true}, {h: 2, noData: true}, {h: 3, value: 60, count: 3}},
+		},
+		{
+			name:    "without a window",
+			o:       Objective{Metric: "failure_rate", Max: 0.2},
+			results: []result{rtts(0, 10, -1), rtts(5, 10, 10)},
+			want:    []violation{{h: -1, value: 0.25, count: 4}},
+		},
+		{
+			name:    "min_count without a window",
+			o:       Objective{Metric: "p50", Max: 50, MinCount: 5},
+			results: []result{rtts(0, 10, 20), rtts(5, 30, 40)},
+			want:    []violation{{h: -1, count: 4, noData: true}},
+		},
+	}
+	for _, tc := range tests {
+		tc.o.Name, tc.o.Group = tc.name, "probe"
+		if err := tc.o.check(); err != nil {
+			t.Fatalf("%v: check() failed: %v", tc.name, err)
+		}
+		e := &Evaluator{Objectives: []Objective{tc.o}, results: tc.results}
+		got := e.Evaluate(messages.MeasurementResponseMessage{Id: 5001, Type: "dns"}, "FRA", nil)
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: Evaluate() = %v, want %d violations", tc.name, got, len(tc.want))
+			continue
+		}
+		for i, w := range tc.want {
+			g := got[i]
+			var start time.Time
+			if w.h >= 0 {
+				start = time.Unix(int64(hour+w.h*3600), 0).UTC()
+			}
+			if g.Group != "1" || !g.Start.Equal(start) || g.Value != w.value || g.Count != w.count || g.NoData != w.noData {
+				t.Errorf("%v: violation %d = %v, want %+v", tc.name, i, g, w)
+			}
+		}
+	}
+}
+
+func TestEvaluateNoResults(t *testing.T) {
+	o := Objective{Name: "dns-p95", Type: "dns", Metric: "p95", Max: 50}
+	e := &Evaluator{Objectives: []Objective{o, {Name: "ping-loss", Type: "ping", Metric: "loss"}}}
+	got := e.Evaluate(messages.MeasurementResponseMessage{Id: 5001, Type: "dns"}, "FRA", nil)
+	if len(got) != 1 || got[0].Objective.Name != "dns-p95" || !got[0].NoData || got[0].Group != "all" {
+		t.Errorf("Evaluate() = %v, want dns-p95 without data", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, o := range []Objective{
+		{Name: "metric", Metric: "p42"},
+		{Name: "group", Metric: "p50", Group: "city"},
+		{Name: "window", Metric: "p50", Window: "hourly"},
+		{Name: "short window", Metric: "p50", Window: "10ms"},
+	} {
+		if err := o.check(); err == nil {
+			t.Errorf("check() of a bad %v succeeded, want an error", o.Name)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Detector finds sustained shifts in a bucketed time series. Each bucket is
+// compared to a baseline, the rolling median of the preceding normal buckets;
+// a shift which lasts for Sustain buckets is reported as an Event.
+type Detector struct {
+	// Window is the number of buckets in the rolling baseline.
+	Window int
+	// Sustain is the number of consecutive shifted buckets which make an Event.
+	Sustain int
+	// LatencyShift is the smallest median latency shift (ms) which counts.
+	LatencyShift float64
+	// LatencyRatio is the smallest median latency shift, relative to the baseline.
+	LatencyRatio float64
+	// ErrorShift is the smallest failure rate increase which counts.
+	ErrorShift float64
+}
+
+// DefaultDetector suits 5 minute buckets: an hour of baseline, and shifts
+// which last for 15 minutes.
+var DefaultDetector = Detector{
+	Window:       12,
+	Sustain:      3,
+	LatencyShift: 10,
+	LatencyRatio: 0.25,
+	ErrorShift:   0.1,
+}
+
+// Event is a sustained shift in latency or failure rate.
+type Event struct {
+	Kind     string // latency or errors
+	PrbId    int32  // 0 for the whole measurement
+	Onset    time.Time
+	End      time.Time // start of the last shifted bucket
+	Buckets  int
+	Baseline float64 // ms for latency, a fraction for errors
+	Level    float64 // median over the shifted buckets
+}
+
+// Magnitude is the size of the shift, negative when latency dropped.
+func (e Event) Magnitude() float64 {
+	return e.Level - e.Baseline
+}
+
+func (e Event) String() string {
+	who := "measurement"
+	if e.PrbId != 0 {
+		who = fmt.Sprintf("probe %d", e.PrbId)
+	}
+	if e.Kind == "errors" {
+		return fmt.Sprintf("%v error spike on %v: buckets: %d failures %0.2f%% -> %0.2f%% (%+0.2f%%)",
+			e.Onset.UTC().Format(time.RFC3339), who, e.Buckets,
+			100*e.Baseline, 100*e.Level, 100*e.Magnitude())
+	}
+	return fmt.Sprintf("%v latency shift on %v: buckets: %d p50 %0.3f -> %0.3f (%+0.3f ms)",
+		e.Onset.UTC().Format(time.RFC3339), who, e.Buckets,
+		e.Baseline, e.Level, e.Magnitude())
+}
+
+// Detect returns the latency shifts and error spikes in a time ordered list
+// of buckets, such as Series.Buckets returns. prbId labels the events.
+func (d Detector) Detect(prbId int32, buckets []Bucket) []Event {
+	latency := d.detect(buckets,
+		func(b Bucket) (float64, bool) {
+			// Only buckets with answers have a latency.
+			return b.P50, b.Count > b.Timeouts+b.Errors
+		},
+		func(base, v float64) bool {
+			return math.Abs(v-base) >= math.Max(d.LatencyShift, d.LatencyRatio*base)
+		})
+	errors := d.detect(buckets,
+		func(b Bucket) (float64, bool) {
+			return b.FailureRate(), b.Count > 0
+		},
+		func(base, v float64) bool {
+			return v-base >= d.ErrorShift
+		})
+
+	var res []Event
+	for _, e := range latency {
+		e.Kind = "latency"
+		e.PrbId = prbId
+		res = append(res, e)
+	}
+	for _, e := range errors {
+		e.Kind = "errors"
+		e.PrbId = prbId
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Onset.Before(res[j].Onset) })
+	return res
+}
+
+// detect runs the rolling median detector over one value of each bucket.
+// value returns the bucket's value and whether it has one, shifted reports
+// whether a value has moved from the baseline.
+func (d Detector) detect(buckets []Bucket, value func(Bucket) (float64, bool), shifted func(base, v float64) bool) []Event {
+	var res []Event
+	var history []float64 // values of the recent normal buckets
+	var run []float64     // values of the current run of shifted buckets
+	var runStart, runEnd time.Time
+	var base float64
+
+	// emit records the current run as an event, if it lasted long enough.
+	emit := func() {
+		if len(run) >= d.Sustain {
+			res = append(res, Event{
+				Onset:    runStart,
+				End:      runEnd,
+				Buckets:  len(run),
+				Baseline: base,
+				Level:    Percentile(Sorted(run), 50),
+			})
+		}
+	}
+
+	for _, b := range buckets {
+		v, ok := value(b)
+		if !ok {
+			continue
+		}
+		if len(history) < d.Window && len(run) == 0 {
+			// Still learning the baseline.
+			history = append(history, v)
+			continue
+		}
+		if len(run) == 0 {
+			base = Percentile(Sorted(history), 50)
+		}
+
+		// A run continues only while the shift is in the same direction.
+		if shifted(base, v) && (len(run) == 0 || (v > base) == (run[0] > base)) {
+			if len(run) == 0 {
+				runStart = b.Start
+			}
+			run = append(run, v)
+			runEnd = b.Start
+			if len(run) >= d.Window {
+				// The shift has lasted a full window, it is the new normal.
+				emit()
+				history = run
+				run = nil
+			}
+			continue
+		}
+
+		emit()
+		// Shifted buckets which did not last are not part of the baseline.
+		run = nil
+		history = append(history, v)
+		if len(history) > d.Window {
+			history = history[len(history)-d.Window:]
+		}
+	}
+	emit()
+	return res
+}
+
+// Affected returns the ids of the probes with an event of the same kind as
+// e, whose onset is within slack of e's onset.
+func Affected(e Event, probeEvents []Event, slack time.Duration) []int32 {
+	var res []int32
+	seen := map[int32]bool{}
+	for _, p := range probeEvents {
+		if p.Kind != e.Kind || seen[p.PrbId] {
+			continue
+		}
+		if d := p.Onset.Sub(e.Onset); d >= -slack && d <= slack {
+			seen[p.PrbId] = true
+			res = append(res, p.PrbId)
+		}
+	}
+	return res
+}
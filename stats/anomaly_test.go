@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2019, 1, 6, 0, 0, 0, 0, time.UTC)
+
+// latencies returns a bucket per value, 5 minutes apart, each with a single
+// answered sample of that latency. A negative value is a bucket in which
+// every sample timed out.
+func latencies(vs ...float64) []Bucket {
+	var res []Bucket
+	for i, v := range vs {
+		b := Bucket{Start: t0.Add(time.Duration(i) * 5 * time.Minute), Summary: Summary{Count: 1, P50: v}}
+		if v < 0 {
+			b.Summary = Summary{Count: 1, Timeouts: 1}
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+func TestDetectLatency(t *testing.T) {
+	d := Detector{Window: 4, Sustain: 2, LatencyShift: 10, LatencyRatio: 0}
+	type event struct {
+		onset           int // bucket index
+		buckets         int
+		baseline, level float64
+	}
+	tests := []struct {
+		name string
+		vs   []float64
+		want []event
+	}{
+		{name: "shorter than the window", vs: []float64{10, 10, 50}},
+		{name: "no baseline left to compare", vs: []float64{10, 10, 10, 10}},
+		{name: "shift shorter than sustain", vs: []float64{10, 10, 10, 10, 30, 10, 10}},
+		{name: "shift of sustain buckets", vs: []float64{10, 10, 10, 10, 30, 30, 10},
+			want: []event{{4, 2, 10, 30}}},
+		{name: "shift at the end", vs: []float64{10, 10, 10, 10, 10, 30, 30},
+			want: []event{{5, 2, 10, 30}}},
+		{name: "below the threshold", vs: []float64{10, 10, 10, 10, 19.9, 19.9, 19.9}},
+		{name: "at the threshold", vs: []float64{10, 10, 10, 10, 20, 20},
+			want: []event{{4, 2, 10, 20}}},
+		{name: "drop", vs: []float64{30, 30, 30, 30, 10, 10},
+			want: []event{{4, 2, 30, 10}}},
+		{name: "direction changes", vs: []float64{20, 20, 20, 20, 40, 0, 40, 0}},
+		// Buckets without answers have no latency, and do not break a run.
+		{name: "unanswered buckets skipped", vs: []float64{10, 10, -1, 10, 10, 30, -1, 30},
+			want: []event{{5, 2, 10, 30}}},
+		// A shift lasting a full window becomes the baseline, so a return
+		// to the old level is a shift of its own.
+		{name: "new normal", vs: []float64{10, 10, 10, 10, 30, 30, 30, 30, 30, 10, 10},
+			want: []event{{4, 4, 10, 30}, {9, 2, 30, 10}}},
+	}
+	for _, tc := range tests {
+		var got []Event
+		for _, e := range d.Detect(7, latencies(tc.vs...)) {
+			if e.Kind == "latency" {
+				got = append(got, e)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: Detect() = %v, want %d events", tc.name, got, len(tc.want))
+			continue
+		}
+		for i, w := range tc.want {
+			e := got[i]
+			if !e.Onset.Equal(t0.Add(time.Duration(w.onset)*5*time.Minute)) || e.Buckets != w.buckets ||
+				e.Baseline != w.baseline || e.Level != w.level || e.PrbId != 7 {
+				t.Errorf("%v: event %d = %v, want %+v", tc.name, i, e, w)
+			}
+		}
+	}
+}
+
+func TestDetectErrors(t *testing.T) {
+	d := Detector{Window: 3, Sustain: 2, LatencyShift: 1000, ErrorShift: 0.5}
+	var buckets []Bucket
+	for i, failed := range []int{0, 0, 0, 5, 10, 0} {
+		buckets = append(buckets, Bucket{
+			Start:   t0.Add(time.Duration(i) * time.Minute),
+			Summary: Summary{Count: 10, Timeouts: failed, P50: 10},
+		})
+	}
+	events := d.Detect(0, buckets)
+	if len(events) != 1 || events[0].Kind != "errors" || events[0].Buckets != 2 ||
+		events[0].Baseline != 0 || events[0].Level != 0.5 {
+		t.Errorf("Detect() = %v, want one error spike of 2 buckets from 0 to 0.5", events)
+	}
+}
+
+func TestAffected(t *testing.T) {
+	e := Event{Kind: "latency", Onset: t0}
+	probes := []Event{
+		{Kind: "latency", PrbId: 1, Onset: t0.Add(-5 * time.Minute)},
+		{Kind: "latency", PrbId: 1, Onset: t0},
+		{Kind: "latency", PrbId: 2, Onset: t0.Add(5 * time.Minute)},
+		{Kind: "latency", PrbId: 3, Onset: t0.Add(6 * time.Minute)},
+		{Kind: "errors", PrbId: 4, Onset: t0},
+	}
+	got := Affected(e, probes, 5*time.Minute)
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Affected() = %v, want [1 2]", got)
+	}
+}
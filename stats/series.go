@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Series rolls samples into fixed-width time buckets.
+type Series struct {
+	width   int32
+	buckets map[int32]*Values
+}
+
+// NewSeries creates an empty Series with buckets of the given width,
+// typically the measurement interval. Widths are whole seconds.
+func NewSeries(width time.Duration) *Series {
+	w := int32(width / time.Second)
+	if w < 1 {
+		w = 1
+	}
+	return &Series{width: w, buckets: map[int32]*Values{}}
+}
+
+// Width returns the width of each bucket.
+func (s *Series) Width() time.Duration {
+	return time.Duration(s.width) * time.Second
+}
+
+// BucketStart returns the start of the bucket holding timestamp ts.
+func (s *Series) BucketStart(ts int32) int32 {
+	return ts - ts%s.width
+}
+
+// Add records a single sample, in the bucket of its timestamp.
+func (s *Series) Add(smp Sample) {
+	b := s.BucketStart(smp.Timestamp)
+	v, ok := s.buckets[b]
+	if !ok {
+		v = &Values{}
+		s.buckets[b] = v
+	}
+	v.Add(smp)
+}
+
+// AddPackets records ping packet counts in the bucket of timestamp ts.
+func (s *Series) AddPackets(ts int32, sent, rcvd int) {
+	b := s.BucketStart(ts)
+	v, ok := s.buckets[b]
+	if !ok {
+		v = &Values{}
+		s.buckets[b] = v
+	}
+	v.AddPackets(sent, rcvd)
+}
+
+// Bucket is the summary of the samples within a single time bucket.
+type Bucket struct {
+	Start time.Time
+	Summary
+}
+
+func (b Bucket) String() string {
+	res := fmt.Sprintf(
+		"%v count: %d p50: %0.3f p90: %0.3f p95: %0.3f p99: %0.3f failures: %0.2f%%",
+		b.Start.UTC().Format(time.RFC3339), b.Count, b.P50, b.P90, b.P95, b.P99,
+		100*b.FailureRate())
+	if b.Sent > 0 {
+		res += fmt.Sprintf(" loss: %0.2f%%", 100*b.Loss())
+	}
+	return res
+}
+
+// Buckets returns the summary of each bucket holding samples, in time order.
+// Buckets without samples are not returned.
+func (s *Series) Buckets() []Bucket {
+	var starts []int32
+	for b := range s.buckets {
+		starts = append(starts, b)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var res []Bucket
+	for _, b := range starts {
+		res = append(res, Bucket{
+			Start:   time.Unix(int64(b), 0).UTC(),
+			Summary: s.buckets[b].Summary(),
+		})
+	}
+	return res
+}
+
+// TimeSeries accumulates the results of a measurement into time buckets,
+// overall and per probe.
+type TimeSeries struct {
+	All    *Series
+	Probes map[int32]*Series
+	width  time.Duration
+}
+
+// NewTimeSeries creates an empty TimeSeries with buckets of the given width.
+func NewTimeSeries(width time.Duration) *TimeSeries {
+	return &TimeSeries{
+		All:    NewSeries(width),
+		Probes: map[int32]*Series{},
+		width:  width,
+	}
+}
+
+// Add records the samples of a single result.
+func (t *TimeSeries) Add(m messages.MeasurementResultMessage) {
+	p, ok := t.Probes[m.PrbId]
+	if !ok {
+		p = NewSeries(t.width)
+		t.Probes[m.PrbId] = p
+	}
+	for _, s := range Samples(m) {
+		t.All.Add(s)
+		p.Add(s)
+	}
+	if m.Type == "ping" {
+		t.All.AddPackets(m.Timestamp, m.Sent, m.Rcvd)
+		p.AddPackets(m.Timestamp, m.Sent, m.Rcvd)
+	}
+}
+
+// ProbeIds returns the ids of the probes seen, sorted.
+func (t *TimeSeries) ProbeIds() []int32 {
+	var res []int32
+	for id := range t.Probes {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
@@ -0,0 +1,316 @@
+// stats computes latency and failure statistics over measurement results,
+// for any measurement type.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+// Sample is a single latency observation taken from a result. Latency is in
+// milliseconds, a Sample which timed out or failed has no latency.
+type Sample struct {
+	PrbId     int32
+	Timestamp int32
+	Rtt       float64
+	Timeout   bool
+	Error     bool
+}
+
+// Samples extracts the latency observations from a result: one per packet
+// for ping and ntp, one per result for dns, http and traceroute.
+func Samples(m messages.MeasurementResultMessage) []Sample {
+	base := Sample{PrbId: m.PrbId, Timestamp: m.Timestamp}
+	if m.Failed() {
+		base.Timeout = m.TimedOut()
+		base.Error = !base.Timeout
+		return []Sample{base}
+	}
+
+	var res []Sample
+	switch m.Type {
+	case "dns":
+		base.Rtt = m.Result.Rt
+		res = append(res, base)
+	case "ping", "ntp":
+		for _, e := range m.Result.Entries {
+			s := base
+			switch {
+			case e.Timeout():
+				s.Timeout = true
+			case e.Error != "":
+				s.Error = true
+			case m.Type == "ntp":
+				// NTP reports round trip times in seconds.
+				s.Rtt = e.Rtt * 1000
+			default:
+				s.Rtt = e.Rtt
+			}
+			res = append(res, s)
+		}
+	case "http":
+		for _, e := range m.Result.Entries {
+			s := base
+			if e.Err != "" {
+				s.Error = true
+			} else {
+				s.Rtt = e.Rt
+			}
+			res = append(res, s)
+		}
+	case "traceroute":
+		res = append(res, traceroute(base, m))
+	default:
+		base.Rtt = m.Result.Rt
+		res = append(res, base)
+	}
+	return res
+}
+
+// traceroute returns the fastest reply from the destination at the last hop,
+// a trace which did not reach the destination has timed out.
+func traceroute(s Sample, m messages.MeasurementResultMessage) Sample {
+	if len(m.Result.Entries) == 0 {
+		s.Error = true
+		return s
+	}
+	last := m.Result.Entries[len(m.Result.Entries)-1]
+	if last.Error != "" {
+		s.Error = true
+		return s
+	}
+	found := false
+	for _, r := range last.Replies {
+		if r.Timeout() || r.From != m.DstAddr {
+			continue
+		}
+		if !found || r.Rtt < s.Rtt {
+			s.Rtt = r.Rtt
+		}
+		found = true
+	}
+	s.Timeout = !found
+	return s
+}
+
+// Summary holds the statistics over a set of samples. Latencies are in
+// milliseconds and only include the samples which received an answer.
+type Summary struct {
+	Count    int
+	Timeouts int
+	Errors   int
+	Min, Max float64
+	Mean     float64
+	StdDev   float64
+	P50, P90 float64
+	P95, P99 float64
+	// Sent and Rcvd count ping packets, from which Loss is computed.
+	Sent, Rcvd int
+}
+
+// TimeoutRate is the fraction of samples which timed out.
+func (s Summary) TimeoutRate() float64 {
+	return rate(s.Timeouts, s.Count)
+}
+
+// ErrorRate is the fraction of samples which failed, other than by timeout.
+func (s Summary) ErrorRate() float64 {
+	return rate(s.Errors, s.Count)
+}
+
+// FailureRate is the fraction of samples which timed out or failed.
+func (s Summary) FailureRate() float64 {
+	return rate(s.Timeouts+s.Errors, s.Count)
+}
+
+// Loss is the fraction of ping packets sent which were not received.
+func (s Summary) Loss() float64 {
+	return rate(s.Sent-s.Rcvd, s.Sent)
+}
+
+func (s Summary) String() string {
+	res := fmt.Sprintf(
+		"count: %d min: %0.3f max: %0.3f mean: %0.3f stddev: %0.3f p50: %0.3f p90: %0.3f p95: %0.3f p99: %0.3f timeouts: %0.2f%% errors: %0.2f%%",
+		s.Count, s.Min, s.Max, s.Mean, s.StdDev, s.P50, s.P90, s.P95, s.P99,
+		100*s.TimeoutRate(), 100*s.ErrorRate())
+	if s.Sent > 0 {
+		res += fmt.Sprintf(" loss: %0.2f%%", 100*s.Loss())
+	}
+	return res
+}
+
+func rate(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// Values accumulates samples, to be summarized once all are added.
+type Values struct {
+	rtts       []float64
+	count      int
+	timeouts   int
+	errors     int
+	sent, rcvd int
+}
+
+// Add records a single sample.
+func (v *Values) Add(s Sample) {
+	v.count++
+	switch {
+	case s.Timeout:
+		v.timeouts++
+	case s.Error:
+		v.errors++
+	default:
+		v.rtts = append(v.rtts, s.Rtt)
+	}
+}
+
+// AddPackets records ping packet counts, for the loss calculation.
+func (v *Values) AddPackets(sent, rcvd int) {
+	v.sent += sent
+	v.rcvd += rcvd
+}
+
+// Merge adds all the samples and packets of o to v.
+func (v *Values) Merge(o *Values) {
+	v.rtts = append(v.rtts, o.rtts...)
+	v.count += o.count
+	v.timeouts += o.timeouts
+	v.errors += o.errors
+	v.sent += o.sent
+	v.rcvd += o.rcvd
+}
+
+// Summary computes the statistics over all the samples added.
+func (v *Values) Summary() Summary {
+	s := Summary{
+		Count:    v.count,
+		Timeouts: v.timeouts,
+		Errors:   v.errors,
+		Sent:     v.sent,
+		Rcvd:     v.rcvd,
+	}
+	if len(v.rtts) == 0 {
+		return s
+	}
+	rtts := Sorted(v.rtts)
+	s.Min = rtts[0]
+	s.Max = rtts[len(rtts)-1]
+
+	var total float64
+	for _, r := range rtts {
+		total += r
+	}
+	s.Mean = total / float64(len(rtts))
+	var sq float64
+	for _, r := range rtts {
+		sq += (r - s.Mean) * (r - s.Mean)
+	}
+	s.StdDev = math.Sqrt(sq / float64(len(rtts)))
+
+	s.P50 = Percentile(rtts, 50)
+	s.P90 = Percentile(rtts, 90)
+	s.P95 = Percentile(rtts, 95)
+	s.P99 = Percentile(rtts, 99)
+	return s
+}
+
+// Collector accumulates the samples of a measurement's results, overall
+// and per probe.
+type Collector struct {
+	All    Values
+	Probes map[int32]*Values
+}
+
+// NewCollector creates an empty Collector.
+func NewCollector() *Collector {
+	return &Collector{Probes: map[int32]*Values{}}
+}
+
+// Add records the samples of a single result.
+func (c *Collector) Add(m messages.MeasurementResultMessage) {
+	p, ok := c.Probes[m.PrbId]
+	if !ok {
+		p = &Values{}
+		c.Probes[m.PrbId] = p
+	}
+	for _, s := range Samples(m) {
+		c.All.Add(s)
+		p.Add(s)
+	}
+	if m.Type == "ping" {
+		c.All.AddPackets(m.Sent, m.Rcvd)
+		p.AddPackets(m.Sent, m.Rcvd)
+	}
+}
+
+// ProbeIds returns the ids of the probes seen, sorted.
+func (c *Collector) ProbeIds() []int32 {
+	var res []int32
+	for id := range c.Probes {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Group is the combined values of the probes which share a key, such as
+// a country or ASN.
+type Group struct {
+	Key    string
+	Probes []int32
+	Values Values
+}
+
+// GroupBy merges the per-probe values into groups, by the key each probe
+// maps to. Probes mapped to an empty key are grouped as "unknown". Groups
+// are sorted by key.
+func (c *Collector) GroupBy(key func(prbId int32) string) []*Group {
+	groups := map[string]*Group{}
+	for _, id := range c.ProbeIds() {
+		k := key(id)
+		if k == "" {
+			k = "unknown"
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &Group{Key: k}
+			groups[k] = g
+		}
+		g.Probes = append(g.Probes, id)
+		g.Values.Merge(c.Probes[id])
+	}
+
+	var res []*Group
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Sorted returns a sorted copy of the values.
+func Sorted(v []float64) []float64 {
+	res := append([]float64(nil), v...)
+	sort.Float64s(res)
+	return res
+}
+
+// Percentile returns the p'th percentile of the sorted values, using the
+// nearest-rank method.
+func Percentile(v []float64, p float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(v))))
+	if rank < 1 {
+		rank = 1
+	}
+	return v[rank-1]
+}
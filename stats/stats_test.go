@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/morrowc/ripe-atlas/messages"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		v    []float64
+		p    float64
+		want float64
+	}{
+		{nil, 50, 0},
+		{[]float64{7}, 0, 7},
+		{[]float64{7}, 99, 7},
+		{ten, 0, 1},
+		{ten, 10, 1},
+		{ten, 11, 2},
+		{ten, 50, 5},
+		{ten, 90, 9},
+		{ten, 95, 10},
+		{ten, 100, 10},
+		{[]float64{1, 2, 3, 4}, 50, 2},
+		{[]float64{1, 2, 3, 4}, 51, 3},
+	}
+	for _, tc := range tests {
+		if got := Percentile(tc.v, tc.p); got != tc.want {
+			t.Errorf("Percentile(%v, %v) = %v, want %v", tc.v, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var v Values
+	for _, rtt := range []float64{40, 10, 30, 20} {
+		v.Add(Sample{Rtt: rtt})
+	}
+	v.Add(Sample{Timeout: true})
+	v.Add(Sample{Error: true, Rtt: 1000})
+	v.AddPackets(10, 7)
+	s := v.Summary()
+
+	if s.Count != 6 || s.Timeouts != 1 || s.Errors != 1 {
+		t.Errorf("Summary() count: %d timeouts: %d errors: %d, want 6, 1, 1", s.Count, s.Timeouts, s.Errors)
+	}
+	// Failed samples are left out of the latencies.
+	if s.Min != 10 || s.Max != 40 || s.Mean != 25 || s.P50 != 20 || s.P99 != 40 {
+		t.Errorf("Summary() = %v, want min 10 max 40 mean 25 p50 20 p99 40", s)
+	}
+	if want := math.Sqrt(125); math.Abs(s.StdDev-want) > 1e-9 {
+		t.Errorf("Summary() stddev = %v, want %v", s.StdDev, want)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"timeout rate", s.TimeoutRate(), 1.0 / 6},
+		{"error rate", s.ErrorRate(), 1.0 / 6},
+		{"failure rate", s.FailureRate(), 2.0 / 6},
+		{"loss", s.Loss(), 0.3},
+	} {
+		if math.Abs(tc.got-tc.want) > 1e-9 {
+			t.Errorf("%v = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	// Nothing added: no latencies, and no rates to divide by zero.
+	var empty Values
+	if s := empty.Summary(); s.Count != 0 || s.P50 != 0 || s.FailureRate() != 0 || s.Loss() != 0 {
+		t.Errorf("empty Summary() = %v, want zeros", s)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var a, b Values
+	a.Add(Sample{Rtt: 5})
+	a.AddPackets(3, 3)
+	b.Add(Sample{Rtt: 15})
+	b.Add(Sample{Timeout: true})
+	b.AddPackets(3, 1)
+	a.Merge(&b)
+	s := a.Summary()
+	if s.Count != 3 || s.Mean != 10 || s.Sent != 6 || s.Rcvd != 4 {
+		t.Errorf("merged Summary() = %v, want count 3 mean 10 sent 6 rcvd 4", s)
+	}
+}
+
+func result(t *testing.T, js string) messages.MeasurementResultMessage {
+	t.Helper()
+	var m messages.MeasurementResultMessage
+	if err := json.Unmarshal([]byte(js), &m); err != nil {
+		t.Fatalf("failed to decode %v: %v", js, err)
+	}
+	return m
+}
+
+func TestSamples(t *testing.T) {
+	tests := []struct {
+		name string
+		js   string
+		want []Sample
+	}{
+		{
+			name: "ping",
+			js:   `{"type": "ping", "prb_id": 1, "timestamp": 100, "result": [{"rtt": 1.5}, {"x": "*"}, {"error": "sendto failed"}]}`,
+			want: []Sample{{PrbId: 1, Timestamp: 100, Rtt: 1.5}, {PrbId: 1, Timestamp: 100, Timeout: true}, {PrbId: 1, Timestamp: 100, Error: true}},
+		},
+		{
+			name: "ntp in seconds",
+			js:   `{"type": "ntp", "prb_id": 2, "result": [{"rtt": 0.025}]}`,
+			want: []Sample{{PrbId: 2, Rtt: 25}},
+		},
+		{
+			name: "dns",
+			js:   `{"type": "dns", "prb_id": 3, "result": {"rt": 12.5}}`,
+			want: []Sample{{PrbId: 3, Rtt: 12.5}},
+		},
+		{
+			name: "dns timeout",
+			js:   `{"type": "dns", "prb_id": 4, "error": {"timeout": 5000}}`,
+			want: []Sample{{PrbId: 4, Timeout: true}},
+		},
+		{
+			name: "dns error",
+			js:   `{"type": "dns", "prb_id": 5, "error": {"socket": "connect failed"}}`,
+			want: []Sample{{PrbId: 5, Error: true}},
+		},
+		{
+			name: "http",
+			js:   `{"type": "http", "prb_id": 6, "result": [{"rt": 80.5, "res": 200}, {"err": "connect: Connection refused"}]}`,
+			want: []Sample{{PrbId: 6, Rtt: 80.5}, {PrbId: 6, Error: true}},
+		},
+		{
+			name: "traceroute reached",
+			js: `{"type": "traceroute", "prb_id": 7, "dst_addr": "192.0.2.1", "result": [
+				{"hop": 1, "result": [{"from": "10.0.0.1", "rtt": 1}]},
+				{"hop": 2, "result": [{"from": "192.0.2.1", "rtt": 9}, {"x": "*"}, {"from": "192.0.2.1", "rtt": 8}]}]}`,
+			want: []Sample{{PrbId: 7, Rtt: 8}},
+		},
+		{
+			name: "traceroute unreachable",
+			js: `{"type": "traceroute", "prb_id": 8, "dst_addr": "192.0.2.1", "result": [
+				{"hop": 1, "result": [{"from": "10.0.0.1", "rtt": 1, "err": 1}]},
+				{"hop": 255, "result": [{"x": "*"}, {"x": "*"}, {"x": "*"}]}]}`,
+			want: []Sample{{PrbId: 8, Timeout: true}},
+		},
+	}
+	for _, tc := range tests {
+		got := Samples(result(t, tc.js))
+		if len(got) != len(tc.want) {
+			t.Errorf("%v: Samples() = %+v, want %+v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%v: sample %d = %+v, want %+v", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+}